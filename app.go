@@ -1,7 +1,6 @@
 package main
 
 import (
-	"archive/zip"
 	"bytes"
 	"crypto/sha512"
 	"encoding/hex"
@@ -10,6 +9,7 @@ import (
 	"net/http"
 	"os"
 	"path/filepath"
+	"sync"
 	"time"
 
 	"github.com/ghetzel/diecast"
@@ -20,8 +20,8 @@ import (
 	"github.com/ghetzel/go-stockutil/stringutil"
 	"github.com/ghetzel/go-stockutil/typeutil"
 	"github.com/mcuadros/go-defaults"
+	"golang.org/x/exp/mmap"
 	"golang.org/x/tools/godoc/vfs"
-	"golang.org/x/tools/godoc/vfs/zipfs"
 	"gopkg.in/yaml.v2"
 )
 
@@ -46,7 +46,27 @@ var AppSearchPaths = func() []string {
 }()
 
 var AppConfigFilename = `/app.yaml`
-var AppMessageBuffer = 1
+
+// AppMessageBuffer is the number of unconsumed events a single /hydra/v1/events subscriber may
+// have queued before the hub starts dropping (rather than blocking on) further publishes to it.
+var AppMessageBuffer = 64
+
+// APIError is the JSON shape errors take when serialized by the hydra HTTP API. Code comes from
+// the same sentinel taxonomy (see errors.go) the manifest/fetch layer uses, so a bad-bundle error
+// surfaced while loading an app bundle and one surfaced while fetching a manifest-described
+// bundle serialize identically.
+type APIError struct {
+	Code    ErrorCode `json:"code"`
+	Message string    `json:"message"`
+}
+
+func apiError(err error) *APIError {
+	if err == nil {
+		return nil
+	}
+
+	return &APIError{Code: CodeForError(err), Message: err.Error()}
+}
 
 type AppFunc func(*App) error
 
@@ -70,39 +90,200 @@ func (app *Message) Set(key string, value interface{}) {
 }
 
 type AppConfig struct {
-	URL        string          `yaml:"url,omitempty"      json:"url"`
-	Name       string          `yaml:"name"               json:"name"       default:"Hydra App"`
-	Width      int             `yaml:"width,omitempty"    json:"height"     default:"800"`
-	Height     int             `yaml:"height,omitempty"   json:"width"      default:"600"`
-	Fullscreen bool            `yaml:"fullscreen"         json:"fullscreen" default:"false"`
-	Backend    *diecast.Server `yaml:"backend,omitempty"  json:"backend"`
-	Services   *ProcessManager `yaml:"services,omitempty" json:"services"`
+	URL         string          `yaml:"url,omitempty"           json:"url"`
+	Name        string          `yaml:"name"                    json:"name"           default:"Hydra App"`
+	Width       int             `yaml:"width,omitempty"         json:"height"         default:"800"`
+	Height      int             `yaml:"height,omitempty"        json:"width"          default:"600"`
+	Fullscreen  bool            `yaml:"fullscreen"              json:"fullscreen"     default:"false"`
+	Backend     *diecast.Server `yaml:"backend,omitempty"       json:"backend"`
+	Services    *ProcessManager `yaml:"services,omitempty"      json:"services"`
+	TrustedKeys []string        `yaml:"trusted_keys,omitempty"  json:"trusted_keys"`
+	// WindowBackend selects which Windowable implementation CreateWindow builds: "webview"
+	// (default) for the embedded webview_go view, or "chrome" to drive the user's installed
+	// Chrome/Chromium over the DevTools protocol instead (see window_chrome.go).
+	WindowBackend string `yaml:"window_backend,omitempty" json:"window_backend" default:"webview"`
+	// ChromeArgs is a space-separated list of extra arguments passed through to the Chrome
+	// binary when WindowBackend is "chrome", e.g. "--disable-gpu --lang=en-US".
+	ChromeArgs string `yaml:"chrome_args,omitempty"    json:"chrome_args"`
 }
 
 type App struct {
-	Config   *AppConfig `json:"config"`
-	window   Messagable
-	path     string
-	bundle   []byte
-	fs       vfs.FileSystem
-	messages chan *Message
+	Config    *AppConfig `json:"config"`
+	window    Messagable
+	path      string
+	bundle    []byte
+	bundleAt  *mmap.ReaderAt
+	fs        vfs.FileSystem
+	hub       *eventHub
+	handlers  map[string]interface{}
+	windowsMu sync.Mutex
+	windows   map[string]WindowHandle
+	windowSeq int
+	logSubsMu sync.Mutex
+	logSubs   map[string]bool
+}
+
+// subscribeLogsOnce reports whether name's output is already being forwarded to its logs:<name>
+// topic, and registers it as subscribed as a side effect. dispatchControlMessage's "logs" case
+// uses this so a client that sends "logs" more than once for the same program (e.g. after a
+// websocket reconnect) doesn't stack a duplicate program.OnOutput forwarder on top of the
+// existing one.
+func (app *App) subscribeLogsOnce(name string) (already bool) {
+	app.logSubsMu.Lock()
+	defer app.logSubsMu.Unlock()
+
+	if app.logSubs == nil {
+		app.logSubs = make(map[string]bool)
+	}
+
+	already = app.logSubs[name]
+	app.logSubs[name] = true
+	return
+}
+
+// Bind registers fn (a Go function of any signature accepted by Window.Bind) under name so that
+// it is auto-exposed as window.hydra.<name> in the embedded web view once a window exists.
+// Calling Bind before the window is created just queues fn; the webview backend's Window.init
+// binds every queued handler when that window first runs (or, for OpenWindow, when it opens).
+// The Chrome backend does not bind queued handlers. Calling it after the window exists binds
+// immediately.
+func (app *App) Bind(name string, fn interface{}) error {
+	if err := validateBindFunc(fn); err != nil {
+		return err
+	}
+
+	if binder, ok := app.window.(Binder); ok {
+		return binder.Bind(name, fn)
+	}
+
+	if app.handlers == nil {
+		app.handlers = make(map[string]interface{})
+	}
+
+	app.handlers[name] = fn
+	return nil
+}
+
+// Publish fans payload out, tagged with topic (which becomes the resulting Message's ID), to
+// every current /hydra/v1/events subscriber of that topic or of the catch-all "*" topic, and
+// appends it to topic's replay backlog so a client that reconnects with a resume cursor (see
+// serveEvents) picks up whatever it missed. It returns the sequence number the message was
+// assigned, which is the same cursor a client would pass back in to resume after this point.
+func (app *App) Publish(topic string, payload map[string]interface{}) uint64 {
+	return app.hub.publish(topic, &Message{
+		ID:      topic,
+		Payload: payload,
+		SentAt:  time.Now(),
+	})
+}
+
+// hasBundle reports whether app was loaded from a bundle file (zip/tar.gz/packed) rather than a
+// plain directory -- directory-backed apps have no bundle file to check or sign.
+func (app *App) hasBundle() bool {
+	return app.bundle != nil || app.bundleAt != nil
 }
 
+// primaryWindowID is the fixed key app's first (and, without OpenWindow, only) window is
+// registered under in app.windows.
+const primaryWindowID = `main`
+
 func (app *App) SetWindow(win Messagable) {
 	app.window = win
+
+	app.windowsMu.Lock()
+	defer app.windowsMu.Unlock()
+
+	if app.windows == nil {
+		app.windows = make(map[string]WindowHandle)
+	}
+
+	if wh, ok := win.(WindowHandle); ok {
+		app.windows[primaryWindowID] = wh
+	}
+}
+
+// windowByID returns the open window registered under id, or ok=false if there isn't one.
+func (app *App) windowByID(id string) (win WindowHandle, ok bool) {
+	app.windowsMu.Lock()
+	defer app.windowsMu.Unlock()
+
+	win, ok = app.windows[id]
+	return
+}
+
+// OpenWindow creates and shows an auxiliary window (a dialog, a preference pane, a second
+// display, ...) running cfg, using the same WindowBackend selection CreateWindow does, and
+// returns it tagged with a new "win-N" ID that window.close/window.focus/window.navigate (and
+// the JS-side hydra.openWindow, see CreateWindow) target it by.
+//
+// webview_go's underlying GTK/Cocoa/Win32 event loop requires every window it drives be created
+// on the same OS thread as the loop itself -- the same constraint runtime.LockOSThread exists
+// for. Rather than stand up a second dispatch mechanism for that, OpenWindow reuses the primary
+// window's own Dispatch (the central queue its event loop already drains, see Window.Dispatch)
+// when the primary window implements Dispatcher; backends that don't need OS thread affinity
+// (e.g. ChromeWindow) just run the constructor inline.
+func (app *App) OpenWindow(cfg *AppConfig) (win WindowHandle, id string, err error) {
+	var resultc = make(chan struct {
+		win WindowHandle
+		err error
+	}, 1)
+
+	var open = func() {
+		win, err := newWindowHandle(cfg)
+
+		if err == nil {
+			// setApp has to happen before init/Navigate below (rather than after, once this
+			// closure's result reaches the caller) so that a backend's init -- which applies the
+			// embedded JS library, binds the app's already-registered Bind handlers, and sets
+			// title/size/fullscreen before navigating -- has an app to bind those handlers from.
+			if ab, ok := win.(interface{ setApp(*App) }); ok {
+				ab.setApp(app)
+			}
+
+			// Backends that implement init (currently just the webview backend's Window) get the
+			// same setup CreateWindow's primary window goes through on first Run; others just
+			// navigate directly, same as before.
+			if initer, ok := win.(interface{ init() error }); ok {
+				err = initer.init()
+			} else {
+				err = win.Navigate(cfg.URL)
+			}
+		}
+
+		resultc <- struct {
+			win WindowHandle
+			err error
+		}{win, err}
+	}
+
+	if d, ok := app.window.(Dispatcher); ok {
+		d.Dispatch(open)
+	} else {
+		open()
+	}
+
+	var result = <-resultc
+
+	if result.err != nil {
+		return nil, ``, result.err
+	}
+
+	app.windowsMu.Lock()
+	app.windowSeq++
+	id = fmt.Sprintf("win-%d", app.windowSeq)
+	app.windows[id] = result.win
+	app.windowsMu.Unlock()
+
+	return result.win, id, nil
 }
 
 // Ensures that the application configuration is able to be run.
 func (app *App) Validate() error {
 	if app.fs == nil {
-		var r = bytes.NewReader(app.bundle)
-
-		if zr, err := zip.NewReader(r, int64(r.Len())); err == nil {
-			app.fs = zipfs.New(&zip.ReadCloser{
-				Reader: *zr,
-			}, filepath.Base(app.path))
+		if fs, err := buildBundleFS(app); err == nil {
+			app.fs = fs
 		} else {
-			return fmt.Errorf("bad bundle: zip: %v", err)
+			return err
 		}
 	}
 
@@ -117,13 +298,13 @@ func (app *App) Validate() error {
 				defaults.SetDefaults(app.Config)
 
 				if err := yaml.UnmarshalStrict(b, app.Config); err != nil {
-					return fmt.Errorf("app.yaml: %v", err)
+					return fmt.Errorf("%w: %v", ErrConfigInvalid, err)
 				}
 			} else {
-				return fmt.Errorf("app.yaml: %v", err)
+				return fmt.Errorf("%w: %v", ErrConfigInvalid, err)
 			}
 		} else {
-			return fmt.Errorf("fs: cannot locate %q: %v", AppConfigFilename, err)
+			return fmt.Errorf("%w: cannot locate %q: %v", ErrBadBundle, AppConfigFilename, err)
 		}
 	}
 
@@ -135,7 +316,7 @@ func (app *App) Validate() error {
 		app.Config.Services = new(ProcessManager)
 	}
 
-	app.messages = make(chan *Message, AppMessageBuffer)
+	app.hub = newEventHub()
 
 	return nil
 }
@@ -186,6 +367,15 @@ func (app *App) registerHydraApi(dc *diecast.Server) {
 	dc.Get(`/hydra/v1/assets/:path`, func(w http.ResponseWriter, req *http.Request) {
 		var path = `/` + dc.P(req, `path`).String()
 
+		// A packed bundle's uncompressed assets are served straight out of the mmap via a
+		// zero-copy io.SectionReader (which also gets us Range request support for free via
+		// http.ServeContent) instead of being read fully into a []byte first.
+		if sr, mime, ok := packedAssetSection(app.fs, path); ok {
+			w.Header().Set(`Content-Type`, mime)
+			http.ServeContent(w, req, path, time.Time{}, sr)
+			return
+		}
+
 		if data, err := FS.ReadFile(path); err == nil {
 			var cksum = sha512.Sum512(data)
 
@@ -197,9 +387,9 @@ func (app *App) registerHydraApi(dc *diecast.Server) {
 
 			w.Write(data)
 		} else if os.IsNotExist(err) {
-			httputil.RespondJSON(w, err, 404)
+			httputil.RespondJSON(w, apiError(err), 404)
 		} else {
-			httputil.RespondJSON(w, err)
+			httputil.RespondJSON(w, apiError(err))
 		}
 	})
 
@@ -220,23 +410,101 @@ func (app *App) registerHydraApi(dc *diecast.Server) {
 			if reply, err := app.window.Send(msg); err == nil {
 				httputil.RespondJSON(w, reply)
 			} else {
-				httputil.RespondJSON(w, err)
+				httputil.RespondJSON(w, apiError(err))
 			}
 		} else {
-			httputil.RespondJSON(w, err)
+			httputil.RespondJSON(w, apiError(err))
 		}
 	})
+
+	dc.Get(`/hydra/v1/events`, func(w http.ResponseWriter, req *http.Request) {
+		app.serveEvents(w, req)
+	})
+}
+
+// LoadOptions controls optional validation performed while loading an app bundle.
+type LoadOptions struct {
+	// RequireSignature refuses to load a bundle that does not carry a valid detached
+	// signature (loadpath + ".sig") from one of TrustedKeyPaths.
+	RequireSignature bool
+	TrustedKeyPaths  []string
+}
+
+func (opts LoadOptions) trustedKeys() []string {
+	return append(append([]string{}, opts.TrustedKeyPaths...))
 }
 
-// Load an application from the specified directory or URL pointing to an application bundle, which
-// should be a .zip.  If the given path is not a local directory, it is assumed to be a URL.
-// Supported schemes for URLs are: http:// https:// ftp:// sftp:// and file://.
-func LoadApp(loadpath string) (*App, error) {
+// peekAppConfig reads and parses dir's app.yaml directly off disk, bypassing app.fs (which a
+// manifest-described bundle may not have fully materialized yet).  LoadApp uses this to let a
+// bundle's own already-vendored app.yaml name additional AppConfig.TrustedKeys its manifest-
+// fetched assets must be signed by, independent of whatever --trusted-key flags this particular
+// invocation passed in. Errors are not fatal here: app.Validate reads app.yaml again afterward
+// and is the authoritative source of config-load failures.
+func peekAppConfig(dir string) (*AppConfig, error) {
+	b, err := os.ReadFile(filepath.Join(dir, AppConfigFilename))
+
+	if err != nil {
+		return nil, err
+	}
+
+	var cfg = new(AppConfig)
+	defaults.SetDefaults(cfg)
+
+	if err := yaml.UnmarshalStrict(b, cfg); err != nil {
+		return nil, err
+	}
+
+	return cfg, nil
+}
+
+// Load an application from the specified directory or URL pointing to an application bundle,
+// which may be a .zip, .tar.gz, or packed (see WritePackedBundle) bundle.  If the given path is
+// not a local directory, it is assumed to be a URL.  Supported schemes for URLs are: http://
+// https:// ftp:// sftp:// and file://.
+func LoadApp(loadpath string, opts ...LoadOptions) (*App, error) {
+	var opt LoadOptions
+
+	if len(opts) > 0 {
+		opt = opts[0]
+	}
+
 	var app = new(App)
 	app.path = loadpath
 
 	if fileutil.IsNonemptyDir(loadpath) {
+		// A directory whose assets aren't all present yet, but that does carry a manifest.yaml,
+		// describes a manifest-fetched bundle: materialize whatever's missing (content-addressed
+		// cache, resumable downloads, pluggable fetch backends -- see manifest.go/cache.go) before
+		// treating loadpath as the app's filesystem. Fetch only touches files that fail their own
+		// checksum validation, so an already fully-vendored directory costs nothing extra here.
+		if manifestFile := filepath.Join(loadpath, ManifestFilename); fileutil.FileExists(manifestFile) {
+			var manifest Manifest
+
+			if err := LoadManifestFile(manifestFile, &manifest); err != nil {
+				return nil, err
+			}
+
+			var fetchOpt = FetchOptions{
+				RequireSignature: opt.RequireSignature,
+				TrustedKeyPaths:  opt.trustedKeys(),
+			}
+
+			if cfg, err := peekAppConfig(loadpath); err == nil {
+				fetchOpt.TrustedKeyPaths = append(fetchOpt.TrustedKeyPaths, cfg.TrustedKeys...)
+			}
+
+			if err := manifest.Fetch(loadpath, loadpath, fetchOpt); err != nil {
+				return nil, err
+			}
+		}
+
 		app.fs = vfs.OS(loadpath)
+	} else if ra, ok := localMmap(loadpath); ok {
+		// A local bundle file is mapped rather than read fully into memory up front: Validate
+		// builds app.fs directly over the mapping, so a packed bundle's assets are served
+		// straight out of the page cache instead of doubling RSS with an in-process copy. Zip
+		// and tar.gz bundles still decode their full bytes in Validate either way.
+		app.bundleAt = ra
 	} else if bundle, err := fileutil.OpenWithOptions(loadpath, fileutil.OpenOptions{
 		Timeout: time.Second,
 	}); err == nil {
@@ -250,15 +518,18 @@ func LoadApp(loadpath string) (*App, error) {
 		return nil, err
 	}
 
-	if app == nil {
-		return nil, fmt.Errorf("failed to load application")
-	} else {
-		return app, app.Validate()
+	// Directory-backed apps have no bundle file to sign or check.
+	if app.hasBundle() {
+		if err := verifyBundleSignature(app, loadpath, opt); err != nil {
+			return nil, err
+		}
 	}
+
+	return app, app.Validate()
 }
 
 // Attemp to locate an app bundle by searching
-func FindAppByName(name string) (*App, error) {
+func FindAppByName(name string, opts ...LoadOptions) (*App, error) {
 	var candidates = []string{
 		name,
 	}
@@ -270,7 +541,7 @@ func FindAppByName(name string) (*App, error) {
 	for _, candidate := range candidates {
 		if fileutil.Exists(candidate) {
 			log.Noticef("find: matched %s", candidate)
-			return LoadApp(candidate)
+			return LoadApp(candidate, opts...)
 		} else {
 			log.Debugf("find: trying %s", candidate)
 		}
@@ -278,3 +549,31 @@ func FindAppByName(name string) (*App, error) {
 
 	return nil, fmt.Errorf("app %q not found", name)
 }
+
+// verifyBundleSignature checks the detached signature at loadpath+".sig" (if present) against a
+// Manifest built from app's already-loaded bundle contents (see buildBundleManifest), requiring
+// one of opt.TrustedKeyPaths to validate when opt.RequireSignature is set. Building and hashing
+// a Manifest this way -- rather than checksumming the bundle's raw bytes -- matches the payload
+// Manifest.Sign/Verify and manifest.go's verifyArchiveSignature already use everywhere else in
+// this codebase, so a signature that Manifest.Sign produced over a manifest listing exactly the
+// bundle's files and checksums verifies here.
+func verifyBundleSignature(app *App, loadpath string, opt LoadOptions) error {
+	fs, err := buildBundleFS(app)
+
+	if err != nil {
+		return err
+	}
+
+	app.fs = fs
+
+	manifest, err := buildBundleManifest(fs)
+
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrBadBundle, err)
+	}
+
+	return manifest.verifyArchiveSignature(loadpath, FetchOptions{
+		RequireSignature: opt.RequireSignature,
+		TrustedKeyPaths:  opt.trustedKeys(),
+	})
+}
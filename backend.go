@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/url"
+
+	"github.com/ghetzel/go-stockutil/log"
+)
+
+// FetchBackend retrieves the content named by a URL whose scheme it has been registered
+// against via RegisterFetchBackend.
+type FetchBackend interface {
+	Fetch(rawurl string) (io.ReadCloser, error)
+}
+
+var fetchBackends = make(map[string]FetchBackend)
+
+// RegisterFetchBackend associates scheme (e.g. "s3", "oci", "git+https", "ipfs") with backend,
+// so ManifestFile entries whose Name is an absolute URL in that scheme are retrieved through it
+// instead of the default fileutil-based fetch.
+func RegisterFetchBackend(scheme string, backend FetchBackend) {
+	fetchBackends[scheme] = backend
+}
+
+// fetchBackendFor returns the registered FetchBackend for rawurl's scheme, if any.
+func fetchBackendFor(rawurl string) (FetchBackend, bool) {
+	u, err := url.Parse(rawurl)
+
+	if err != nil || u.Scheme == `` {
+		return nil, false
+	}
+
+	backend, ok := fetchBackends[u.Scheme]
+	return backend, ok
+}
+
+// fetchViaBackend dispatches name to a registered FetchBackend based on its URL scheme, logging
+// which backend handled it.  It returns ok=false when name has no registered scheme, in which
+// case the caller should fall back to the default fetch path.
+func fetchViaBackend(name string) (rc io.ReadCloser, ok bool, err error) {
+	backend, found := fetchBackendFor(name)
+
+	if !found {
+		return nil, false, nil
+	}
+
+	u, _ := url.Parse(name)
+	log.Debugf("fetch: dispatching %s to %s backend", name, u.Scheme)
+
+	rc, err = backend.Fetch(name)
+	return rc, true, err
+}
+
+func errUnsupportedScheme(rawurl string) error {
+	u, _ := url.Parse(rawurl)
+	return fmt.Errorf("no fetch backend registered for scheme %q (%s)", u.Scheme, rawurl)
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+func init() {
+	RegisterFetchBackend(`git+https`, new(gitBackend))
+}
+
+// gitBackend fetches manifest entries named "git+https://host/org/repo//subpath@ref" by doing a
+// shallow, sparse checkout of subpath at ref into a scratch directory and returning the single
+// requested file from it.  It shells out to the system `git` binary rather than vendoring a
+// pure-Go git implementation.
+type gitBackend struct{}
+
+func (self *gitBackend) Fetch(rawurl string) (io.ReadCloser, error) {
+	repoURL, subpath, ref, err := parseGitURL(rawurl)
+
+	if err != nil {
+		return nil, err
+	}
+
+	workdir, err := os.MkdirTemp(``, `hydra-git-`)
+
+	if err != nil {
+		return nil, fmt.Errorf("git: tempdir: %w", err)
+	}
+
+	run := func(args ...string) error {
+		cmd := exec.Command(`git`, args...)
+		cmd.Dir = workdir
+		out, err := cmd.CombinedOutput()
+
+		if err != nil {
+			return fmt.Errorf("git %s: %w: %s", strings.Join(args, ` `), err, out)
+		}
+
+		return nil
+	}
+
+	if err := run(`init`, `-q`); err != nil {
+		return nil, err
+	}
+
+	if err := run(`remote`, `add`, `origin`, repoURL); err != nil {
+		return nil, err
+	}
+
+	if err := run(`sparse-checkout`, `set`, `--`, subpath); err != nil {
+		return nil, err
+	}
+
+	if err := run(`fetch`, `--depth`, `1`, `origin`, `--`, ref); err != nil {
+		return nil, err
+	}
+
+	if err := run(`checkout`, `FETCH_HEAD`); err != nil {
+		return nil, err
+	}
+
+	f, err := os.Open(filepath.Join(workdir, subpath))
+
+	if err != nil {
+		return nil, fmt.Errorf("git: open %s: %w", subpath, err)
+	}
+
+	return &selfCleaningFile{File: f, dir: workdir}, nil
+}
+
+// selfCleaningFile removes its parent scratch directory once the caller closes it.
+type selfCleaningFile struct {
+	*os.File
+	dir string
+}
+
+func (self *selfCleaningFile) Close() error {
+	err := self.File.Close()
+	os.RemoveAll(self.dir)
+	return err
+}
+
+// parseGitURL splits a "git+https://host/org/repo//subpath@ref" manifest entry name into the
+// cloneable repo URL, the subpath within it, and the ref to check out (defaulting to HEAD).
+func parseGitURL(rawurl string) (repoURL string, subpath string, ref string, err error) {
+	var rest = strings.TrimPrefix(rawurl, `git+`)
+	ref = `HEAD`
+
+	if at := strings.LastIndex(rest, `@`); at >= 0 {
+		ref = rest[at+1:]
+		rest = rest[:at]
+	}
+
+	parts := strings.SplitN(rest, `//`, 3)
+
+	if len(parts) < 3 {
+		return ``, ``, ``, fmt.Errorf("git: malformed source %q, expected scheme://host/repo//subpath", rawurl)
+	}
+
+	repoURL = parts[0] + `//` + parts[1]
+	subpath = parts[2]
+
+	// subpath and ref are passed as positional arguments to `git sparse-checkout set` and
+	// `git fetch`; a manifest entry whose subpath or ref starts with "-" would otherwise be
+	// interpreted as a flag (e.g. an injected "--upload-pack=..." turning the fetch into arbitrary
+	// command execution) instead of a path or refspec.
+	if strings.HasPrefix(subpath, `-`) {
+		return ``, ``, ``, fmt.Errorf("git: subpath %q must not begin with '-'", subpath)
+	}
+
+	if strings.HasPrefix(ref, `-`) {
+		return ``, ``, ``, fmt.Errorf("git: ref %q must not begin with '-'", ref)
+	}
+
+	return repoURL, subpath, ref, nil
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+func init() {
+	RegisterFetchBackend(`ipfs`, new(ipfsBackend))
+}
+
+// IPFSGateway is the HTTP gateway used to resolve "ipfs://<cid>/<path>" entries.  Override to
+// point at a local node (e.g. "http://127.0.0.1:8080/ipfs") when one is available.
+var IPFSGateway = `https://ipfs.io/ipfs`
+
+// ipfsBackend fetches manifest entries named "ipfs://<cid>/<path>" by resolving the CID through
+// an HTTP gateway rather than embedding a full IPFS node.
+type ipfsBackend struct{}
+
+func (self *ipfsBackend) Fetch(rawurl string) (io.ReadCloser, error) {
+	var cidAndPath = strings.TrimPrefix(rawurl, `ipfs://`)
+	var url = strings.TrimSuffix(IPFSGateway, `/`) + `/` + cidAndPath
+
+	resp, err := http.Get(url)
+
+	if err != nil {
+		return nil, fmt.Errorf("ipfs: fetch %s: %w", cidAndPath, err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("ipfs: gateway returned %s for %s", resp.Status, cidAndPath)
+	}
+
+	return resp.Body, nil
+}
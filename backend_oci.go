@@ -0,0 +1,46 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/crane"
+)
+
+func init() {
+	RegisterFetchBackend(`oci`, new(ociBackend))
+}
+
+// ociBackend fetches manifest entries named "oci://registry/repo:tag@layer-index" (layer index
+// defaulting to 0) by pulling the referenced artifact and returning the requested layer's
+// uncompressed content, mirroring how container tooling distributes non-image blobs.
+type ociBackend struct{}
+
+func (self *ociBackend) Fetch(rawurl string) (io.ReadCloser, error) {
+	var ref = strings.TrimPrefix(rawurl, `oci://`)
+	var layerIndex = 0
+
+	if at := strings.LastIndex(ref, `@`); at >= 0 {
+		fmt.Sscanf(ref[at+1:], "%d", &layerIndex)
+		ref = ref[:at]
+	}
+
+	img, err := crane.Pull(ref)
+
+	if err != nil {
+		return nil, fmt.Errorf("oci: pull %s: %w", ref, err)
+	}
+
+	layers, err := img.Layers()
+
+	if err != nil {
+		return nil, fmt.Errorf("oci: layers %s: %w", ref, err)
+	}
+
+	if layerIndex < 0 || layerIndex >= len(layers) {
+		return nil, fmt.Errorf("oci: %s has no layer %d", ref, layerIndex)
+	}
+
+	return layers[layerIndex].Uncompressed()
+}
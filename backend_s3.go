@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+func init() {
+	RegisterFetchBackend(`s3`, new(s3Backend))
+}
+
+// s3Backend fetches manifest entries named "s3://bucket/key" from Amazon S3 (or any
+// S3-compatible store reachable via the default AWS credential chain).  The single instance
+// registered in init() is shared across fetchManyToCache's concurrent worker pool (see
+// cache.go), so configure() must only initialize client once no matter how many goroutines
+// call Fetch concurrently.
+type s3Backend struct {
+	configureOnce sync.Once
+	configureErr  error
+	client        *s3.Client
+}
+
+func (self *s3Backend) configure() error {
+	self.configureOnce.Do(func() {
+		cfg, err := config.LoadDefaultConfig(context.Background())
+
+		if err != nil {
+			self.configureErr = fmt.Errorf("s3: load credentials: %w", err)
+			return
+		}
+
+		self.client = s3.NewFromConfig(cfg)
+	})
+
+	return self.configureErr
+}
+
+func (self *s3Backend) Fetch(rawurl string) (io.ReadCloser, error) {
+	if err := self.configure(); err != nil {
+		return nil, err
+	}
+
+	u, err := url.Parse(rawurl)
+
+	if err != nil {
+		return nil, fmt.Errorf("s3: %w", err)
+	}
+
+	var bucket = u.Host
+	var key = strings.TrimPrefix(u.Path, `/`)
+
+	out, err := self.client.GetObject(context.Background(), &s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+
+	if err != nil {
+		return nil, fmt.Errorf("s3: get %s/%s: %w", bucket, key, err)
+	}
+
+	return out.Body, nil
+}
@@ -0,0 +1,732 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ghetzel/go-stockutil/fileutil"
+	"github.com/klauspost/compress/zstd"
+	"golang.org/x/exp/mmap"
+	"golang.org/x/tools/godoc/vfs"
+	"golang.org/x/tools/godoc/vfs/zipfs"
+)
+
+// packedMagic identifies a hydra packed bundle (see WritePackedBundle) at both the start and
+// end of the file, so App.Validate can sniff the format cheaply from a handful of leading bytes
+// and openPackedBundle can confirm the footer it found wasn't read from a truncated file.
+var packedMagic = [8]byte{'H', 'Y', 'D', 'R', 'A', 'P', 'K', '1'}
+
+const packedVersion uint32 = 1
+
+// packedHeader is the fixed-size prefix every packed bundle starts with.
+type packedHeader struct {
+	Magic   [8]byte
+	Version uint32
+	_       uint32 // reserved
+}
+
+const packedHeaderSize = 8 + 4 + 4
+
+// packedFooter is the fixed-size trailer every packed bundle ends with, pointing at the JSON
+// index that immediately precedes it so a reader never has to scan the blob section to find it.
+type packedFooter struct {
+	IndexOffset int64
+	IndexLength int64
+	Magic       [8]byte
+}
+
+const packedFooterSize = 8 + 8 + 8
+
+// packedEntry is one row of a packed bundle's index: where a named asset's bytes live within
+// the blob section, whether they're zstd-compressed, and the checksum/MIME metadata
+// App.Validate needs to serve it without re-deriving either.
+type packedEntry struct {
+	Name       string `json:"name"`
+	Offset     int64  `json:"offset"` // relative to the start of the blob section, i.e. packedHeaderSize
+	Length     int64  `json:"length"`
+	RawLength  int64  `json:"raw_length"`
+	SHA256     string `json:"sha256"`
+	MIME       string `json:"mime"`
+	Compressed bool   `json:"compressed"`
+}
+
+type packedIndex struct {
+	Entries []packedEntry `json:"entries"`
+}
+
+// uncompressibleMIMEPrefixes lists the MIME types WritePackedBundle skips zstd compression for
+// because the underlying format is already compressed; re-compressing them burns CPU on cold
+// start for no space savings and occasionally makes the blob larger.
+var uncompressibleMIMEPrefixes = []string{
+	`image/`,
+	`video/`,
+	`audio/`,
+	`application/zip`,
+	`application/gzip`,
+	`application/x-xz`,
+	`application/x-bzip2`,
+	`font/woff2`,
+}
+
+func shouldCompressMIME(mime string) bool {
+	if mime == `image/svg+xml` {
+		return true // textual despite the image/ prefix, and compresses well
+	}
+
+	for _, prefix := range uncompressibleMIMEPrefixes {
+		if strings.HasPrefix(mime, prefix) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// WritePackedBundle walks srcdir and writes a packed-format bundle (see `hydra generate
+// --format=packed`) to outfile: a header, the concatenated bytes of every file (optionally
+// zstd-compressed per WritePackedBundle's MIME-based heuristic), a JSON index mapping each
+// name to its (offset, length, sha256, mime), and a footer pointing at that index.
+//
+// Unlike zip or tar.gz, a packed bundle is never unpacked wholesale: App.Validate mmaps the
+// whole file and hands out io.SectionReaders directly over the mapping for every uncompressed
+// entry, which is why media assets (the bulk of a typical app bundle, and already compressed
+// in their own right) are the ones left uncompressed here.
+func WritePackedBundle(srcdir string, outfile string) error {
+	out, err := os.Create(outfile)
+
+	if err != nil {
+		return fmt.Errorf("packed: create %s: %w", outfile, err)
+	}
+
+	defer out.Close()
+
+	if err := binary.Write(out, binary.LittleEndian, &packedHeader{
+		Magic:   packedMagic,
+		Version: packedVersion,
+	}); err != nil {
+		return fmt.Errorf("packed: write header: %w", err)
+	}
+
+	enc, err := zstd.NewWriter(nil)
+
+	if err != nil {
+		return fmt.Errorf("packed: zstd: %w", err)
+	}
+
+	defer enc.Close()
+
+	var index packedIndex
+	var offset int64
+
+	if err := filepath.Walk(srcdir, func(fpath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if info.IsDir() {
+			return nil
+		}
+
+		rel, err := filepath.Rel(srcdir, fpath)
+
+		if err != nil {
+			return err
+		}
+
+		raw, err := os.ReadFile(fpath)
+
+		if err != nil {
+			return fmt.Errorf("%s: %w", rel, err)
+		}
+
+		var cksum = sha256.Sum256(raw)
+		var mime = fileutil.GetMimeType(fpath)
+		var payload = raw
+		var compressed bool
+
+		if shouldCompressMIME(mime) {
+			payload = enc.EncodeAll(raw, nil)
+			compressed = true
+		}
+
+		n, err := out.Write(payload)
+
+		if err != nil {
+			return fmt.Errorf("%s: write: %w", rel, err)
+		}
+
+		index.Entries = append(index.Entries, packedEntry{
+			Name:       filepath.ToSlash(rel),
+			Offset:     offset,
+			Length:     int64(n),
+			RawLength:  int64(len(raw)),
+			SHA256:     hex.EncodeToString(cksum[:]),
+			MIME:       mime,
+			Compressed: compressed,
+		})
+
+		offset += int64(n)
+		return nil
+	}); err != nil {
+		return fmt.Errorf("packed: %w", err)
+	}
+
+	sort.Slice(index.Entries, func(i int, j int) bool {
+		return index.Entries[i].Name < index.Entries[j].Name
+	})
+
+	indexJSON, err := json.Marshal(&index)
+
+	if err != nil {
+		return fmt.Errorf("packed: encode index: %w", err)
+	}
+
+	var indexOffset = packedHeaderSize + offset
+
+	if _, err := out.Write(indexJSON); err != nil {
+		return fmt.Errorf("packed: write index: %w", err)
+	}
+
+	if err := binary.Write(out, binary.LittleEndian, &packedFooter{
+		IndexOffset: indexOffset,
+		IndexLength: int64(len(indexJSON)),
+		Magic:       packedMagic,
+	}); err != nil {
+		return fmt.Errorf("packed: write footer: %w", err)
+	}
+
+	return nil
+}
+
+// bundleFormat identifies which reader App.Validate should build app.fs with.
+type bundleFormat int
+
+const (
+	unknownBundleFormat bundleFormat = iota
+	zipBundleFormat
+	targzBundleFormat
+	packedBundleFormat
+)
+
+// sniffBundleFormat inspects a bundle's leading bytes and reports which of the formats LoadApp
+// knows how to read it is: packed (see WritePackedBundle), zip, or tar.gz.
+func sniffBundleFormat(header []byte) bundleFormat {
+	switch {
+	case len(header) >= 8 && bytes.Equal(header[:8], packedMagic[:]):
+		return packedBundleFormat
+	case len(header) >= 4 && bytes.Equal(header[:4], []byte{'P', 'K', 0x03, 0x04}):
+		return zipBundleFormat
+	case len(header) >= 2 && header[0] == 0x1f && header[1] == 0x8b:
+		return targzBundleFormat
+	default:
+		return unknownBundleFormat
+	}
+}
+
+// buildBundleFS sniffs app's raw bundle bytes (whichever of app.bundle or app.bundleAt LoadApp
+// populated) and constructs the vfs.FileSystem App.Validate serves the bundle's assets through.
+func buildBundleFS(app *App) (vfs.FileSystem, error) {
+	var ra io.ReaderAt
+	var size int64
+
+	if app.bundleAt != nil {
+		ra = app.bundleAt
+		size = int64(app.bundleAt.Len())
+	} else {
+		ra = bytes.NewReader(app.bundle)
+		size = int64(len(app.bundle))
+	}
+
+	var header = make([]byte, 8)
+
+	n, _ := ra.ReadAt(header, 0)
+	header = header[:n]
+
+	switch sniffBundleFormat(header) {
+	case packedBundleFormat:
+		return openPackedBundle(ra, size, app.path)
+	case zipBundleFormat:
+		if zr, err := zip.NewReader(io.NewSectionReader(ra, 0, size), size); err == nil {
+			return zipfs.New(&zip.ReadCloser{Reader: *zr}, filepath.Base(app.path)), nil
+		} else {
+			return nil, fmt.Errorf("%w: zip: %v", ErrBadBundle, err)
+		}
+	case targzBundleFormat:
+		return openTarGzBundle(io.NewSectionReader(ra, 0, size), app.path)
+	default:
+		return nil, fmt.Errorf("%w: unrecognized bundle format", ErrBadBundle)
+	}
+}
+
+// buildBundleManifest walks fs (the vfs.FileSystem built by buildBundleFS over an app's loaded
+// bundle bytes) and returns a Manifest listing every regular file's path and sha256, in the same
+// name+SHA256 shape Manifest.Hash() digests. verifyBundleSignature uses this to check a bundle's
+// detached signature against the same kind of payload Manifest.Sign/Verify produce everywhere
+// else in this codebase, rather than a raw-bytes checksum nothing in the repo actually signs.
+func buildBundleManifest(fs vfs.FileSystem) (*Manifest, error) {
+	var assets ManifestFiles
+
+	var walk func(dir string) error
+
+	walk = func(dir string) error {
+		entries, err := fs.ReadDir(dir)
+
+		if err != nil {
+			return fmt.Errorf("readdir %s: %w", dir, err)
+		}
+
+		for _, entry := range entries {
+			var p = path.Join(dir, entry.Name())
+
+			if entry.IsDir() {
+				if err := walk(p); err != nil {
+					return err
+				}
+
+				continue
+			}
+
+			f, err := fs.Open(p)
+
+			if err != nil {
+				return fmt.Errorf("open %s: %w", p, err)
+			}
+
+			h := sha256.New()
+			_, err = io.Copy(h, f)
+			f.Close()
+
+			if err != nil {
+				return fmt.Errorf("hash %s: %w", p, err)
+			}
+
+			assets = append(assets, &ManifestFile{
+				Name:   strings.TrimPrefix(p, `/`),
+				Size:   entry.Size(),
+				SHA256: hex.EncodeToString(h.Sum(nil)),
+			})
+		}
+
+		return nil
+	}
+
+	if err := walk(`/`); err != nil {
+		return nil, err
+	}
+
+	return &Manifest{Assets: assets}, nil
+}
+
+// readSeekNopCloser adapts an io.ReadSeeker (an *io.SectionReader or *bytes.Reader, in this
+// file) to vfs.ReadSeekCloser for bundle formats with nothing that actually needs closing per
+// open file.
+type readSeekNopCloser struct {
+	io.ReadSeeker
+}
+
+func (readSeekNopCloser) Close() error {
+	return nil
+}
+
+// packedFS is a read-only vfs.FileSystem over a bundle written by WritePackedBundle. When ra is
+// backed by an mmap (see LoadApp), opening an uncompressed entry never copies its bytes into a
+// fresh buffer at all; it hands back an io.SectionReader straight over the mapping.
+type packedFS struct {
+	ra      io.ReaderAt
+	name    string
+	entries map[string]packedEntry
+	dirs    map[string][]os.FileInfo
+	dec     *zstd.Decoder
+}
+
+func openPackedBundle(ra io.ReaderAt, size int64, name string) (*packedFS, error) {
+	if size < packedHeaderSize+packedFooterSize {
+		return nil, fmt.Errorf("%w: too small to be a packed bundle", ErrBadBundle)
+	}
+
+	var footerBuf = make([]byte, packedFooterSize)
+
+	if _, err := ra.ReadAt(footerBuf, size-packedFooterSize); err != nil {
+		return nil, fmt.Errorf("%w: read footer: %v", ErrBadBundle, err)
+	}
+
+	var footer packedFooter
+
+	if err := binary.Read(bytes.NewReader(footerBuf), binary.LittleEndian, &footer); err != nil {
+		return nil, fmt.Errorf("%w: decode footer: %v", ErrBadBundle, err)
+	}
+
+	if footer.Magic != packedMagic {
+		return nil, fmt.Errorf("%w: footer magic mismatch", ErrBadBundle)
+	}
+
+	// IndexOffset/IndexLength are read verbatim from the file; a truncated or malicious bundle
+	// can set either to an out-of-range or negative value, which would otherwise panic the
+	// make([]byte, ...) below (or attempt a huge allocation) before any signature check gets a
+	// chance to reject the bundle. The index must fit entirely between the header and footer.
+	if footer.IndexOffset < packedHeaderSize || footer.IndexLength < 0 ||
+		footer.IndexOffset > size-packedFooterSize ||
+		footer.IndexLength > size-packedFooterSize-footer.IndexOffset {
+		return nil, fmt.Errorf("%w: index offset/length out of range", ErrBadBundle)
+	}
+
+	var indexBuf = make([]byte, footer.IndexLength)
+
+	if _, err := ra.ReadAt(indexBuf, footer.IndexOffset); err != nil {
+		return nil, fmt.Errorf("%w: read index: %v", ErrBadBundle, err)
+	}
+
+	var index packedIndex
+
+	if err := json.Unmarshal(indexBuf, &index); err != nil {
+		return nil, fmt.Errorf("%w: decode index: %v", ErrBadBundle, err)
+	}
+
+	dec, err := zstd.NewReader(nil)
+
+	if err != nil {
+		return nil, fmt.Errorf("packed: zstd: %w", err)
+	}
+
+	var fs = &packedFS{
+		ra:      ra,
+		name:    name,
+		entries: make(map[string]packedEntry, len(index.Entries)),
+		dirs:    make(map[string][]os.FileInfo),
+		dec:     dec,
+	}
+
+	for _, entry := range index.Entries {
+		fs.entries[entry.Name] = entry
+		fs.addToDirIndex(entry)
+	}
+
+	return fs, nil
+}
+
+func (fs *packedFS) addToDirIndex(entry packedEntry) {
+	var dir = path.Dir(entry.Name)
+	fs.dirs[dir] = append(fs.dirs[dir], &packedFileInfo{entry: entry})
+
+	for dir != `.` {
+		var parent = path.Dir(dir)
+
+		for _, fi := range fs.dirs[parent] {
+			if fi.IsDir() && fi.Name() == path.Base(dir) {
+				return
+			}
+		}
+
+		fs.dirs[parent] = append(fs.dirs[parent], &packedFileInfo{isDir: true, name: path.Base(dir)})
+		dir = parent
+	}
+}
+
+func (fs *packedFS) lookup(p string) (packedEntry, bool) {
+	entry, ok := fs.entries[strings.TrimPrefix(p, `/`)]
+	return entry, ok
+}
+
+// openSection returns a zero-copy reader plus MIME type for path, if it names an uncompressed
+// entry; ok is false for a missing path or a zstd-compressed entry, in which case the caller
+// should fall back to fs.Open, which transparently decompresses.
+func (fs *packedFS) openSection(p string) (sr *io.SectionReader, mime string, ok bool) {
+	entry, found := fs.lookup(p)
+
+	if !found || entry.Compressed {
+		return nil, ``, false
+	}
+
+	return io.NewSectionReader(fs.ra, packedHeaderSize+entry.Offset, entry.Length), entry.MIME, true
+}
+
+func (fs *packedFS) Open(p string) (vfs.ReadSeekCloser, error) {
+	entry, ok := fs.lookup(p)
+
+	if !ok {
+		return nil, &os.PathError{Op: `open`, Path: p, Err: os.ErrNotExist}
+	}
+
+	var section = io.NewSectionReader(fs.ra, packedHeaderSize+entry.Offset, entry.Length)
+
+	if !entry.Compressed {
+		return readSeekNopCloser{section}, nil
+	}
+
+	compressed, err := io.ReadAll(section)
+
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", p, err)
+	}
+
+	raw, err := fs.dec.DecodeAll(compressed, make([]byte, 0, entry.RawLength))
+
+	if err != nil {
+		return nil, fmt.Errorf("%s: zstd: %w", p, err)
+	}
+
+	return readSeekNopCloser{bytes.NewReader(raw)}, nil
+}
+
+func (fs *packedFS) Lstat(p string) (os.FileInfo, error) {
+	return fs.Stat(p)
+}
+
+func (fs *packedFS) Stat(p string) (os.FileInfo, error) {
+	trimmed := strings.TrimPrefix(p, `/`)
+
+	if entry, ok := fs.entries[trimmed]; ok {
+		return &packedFileInfo{entry: entry}, nil
+	}
+
+	if trimmed == `` {
+		trimmed = `.`
+	}
+
+	if _, ok := fs.dirs[trimmed]; ok || trimmed == `.` {
+		return &packedFileInfo{isDir: true, name: path.Base(trimmed)}, nil
+	}
+
+	return nil, &os.PathError{Op: `stat`, Path: p, Err: os.ErrNotExist}
+}
+
+func (fs *packedFS) ReadDir(p string) ([]os.FileInfo, error) {
+	trimmed := strings.TrimPrefix(p, `/`)
+
+	if trimmed == `` {
+		trimmed = `.`
+	}
+
+	return fs.dirs[trimmed], nil
+}
+
+func (fs *packedFS) RootType(string) vfs.RootType {
+	return ``
+}
+
+func (fs *packedFS) String() string {
+	return fmt.Sprintf("packed(%s)", fs.name)
+}
+
+// packedFileInfo is the os.FileInfo implementation backing packedFS.Stat/Lstat/ReadDir.
+type packedFileInfo struct {
+	entry packedEntry
+	isDir bool
+	name  string
+}
+
+func (fi *packedFileInfo) Name() string {
+	if fi.isDir {
+		return fi.name
+	}
+
+	return path.Base(fi.entry.Name)
+}
+
+func (fi *packedFileInfo) Size() int64 {
+	return fi.entry.RawLength
+}
+
+func (fi *packedFileInfo) Mode() os.FileMode {
+	if fi.isDir {
+		return os.ModeDir | 0555
+	}
+
+	return 0444
+}
+
+func (fi *packedFileInfo) ModTime() time.Time { return time.Time{} }
+func (fi *packedFileInfo) IsDir() bool        { return fi.isDir }
+func (fi *packedFileInfo) Sys() interface{}   { return nil }
+
+// packedAssetSection returns a zero-copy reader plus MIME type for path if fs is a packed
+// bundle's FileSystem and path names an uncompressed entry within it. ok is false for every
+// other FileSystem (zip, tar.gz, directory) or for a compressed packed entry, in which case the
+// caller should fall back to a normal fs.Open.
+func packedAssetSection(fs vfs.FileSystem, path string) (sr *io.SectionReader, mime string, ok bool) {
+	if p, isPacked := fs.(*packedFS); isPacked {
+		return p.openSection(path)
+	}
+
+	return nil, ``, false
+}
+
+// memFileInfo is the os.FileInfo implementation backing memFS.
+type memFileInfo struct {
+	name  string
+	size  int64
+	mode  os.FileMode
+	mod   time.Time
+	isDir bool
+}
+
+func (fi *memFileInfo) Name() string { return fi.name }
+func (fi *memFileInfo) Size() int64  { return fi.size }
+
+func (fi *memFileInfo) Mode() os.FileMode {
+	if fi.isDir {
+		return fi.mode | os.ModeDir
+	}
+
+	return fi.mode
+}
+
+func (fi *memFileInfo) ModTime() time.Time { return fi.mod }
+func (fi *memFileInfo) IsDir() bool        { return fi.isDir }
+func (fi *memFileInfo) Sys() interface{}   { return nil }
+
+// memFS is a minimal, read-only vfs.FileSystem over a flat map of already-decoded file
+// contents. It backs tar.gz bundles, which (unlike zip or packed) have no seekable index to
+// defer decoding from, so the whole archive is unpacked into memory once up front.
+type memFS struct {
+	name  string
+	files map[string][]byte
+	dirs  map[string][]os.FileInfo
+}
+
+func newMemFS(name string) *memFS {
+	return &memFS{
+		name:  name,
+		files: make(map[string][]byte),
+		dirs:  make(map[string][]os.FileInfo),
+	}
+}
+
+func (fs *memFS) add(name string, data []byte, mode os.FileMode, modTime time.Time) {
+	name = strings.TrimPrefix(path.Clean(`/`+name), `/`)
+	fs.files[name] = data
+
+	fs.dirs[path.Dir(name)] = append(fs.dirs[path.Dir(name)], &memFileInfo{
+		name: path.Base(name),
+		size: int64(len(data)),
+		mode: mode,
+		mod:  modTime,
+	})
+
+	for dir := path.Dir(name); dir != `.`; dir = path.Dir(dir) {
+		var parent, listed = path.Dir(dir), false
+
+		for _, fi := range fs.dirs[parent] {
+			if fi.IsDir() && fi.Name() == path.Base(dir) {
+				listed = true
+			}
+		}
+
+		if listed {
+			break
+		}
+
+		fs.dirs[parent] = append(fs.dirs[parent], &memFileInfo{name: path.Base(dir), mode: 0555, isDir: true})
+	}
+}
+
+func (fs *memFS) Open(name string) (vfs.ReadSeekCloser, error) {
+	name = strings.TrimPrefix(path.Clean(`/`+name), `/`)
+
+	if data, ok := fs.files[name]; ok {
+		return readSeekNopCloser{bytes.NewReader(data)}, nil
+	}
+
+	return nil, &os.PathError{Op: `open`, Path: name, Err: os.ErrNotExist}
+}
+
+func (fs *memFS) Lstat(name string) (os.FileInfo, error) {
+	return fs.Stat(name)
+}
+
+func (fs *memFS) Stat(name string) (os.FileInfo, error) {
+	trimmed := strings.TrimPrefix(path.Clean(`/`+name), `/`)
+
+	if data, ok := fs.files[trimmed]; ok {
+		return &memFileInfo{name: path.Base(trimmed), size: int64(len(data))}, nil
+	}
+
+	if trimmed == `` {
+		trimmed = `.`
+	}
+
+	if _, ok := fs.dirs[trimmed]; ok || trimmed == `.` {
+		return &memFileInfo{name: path.Base(trimmed), isDir: true}, nil
+	}
+
+	return nil, &os.PathError{Op: `stat`, Path: name, Err: os.ErrNotExist}
+}
+
+func (fs *memFS) ReadDir(name string) ([]os.FileInfo, error) {
+	trimmed := strings.TrimPrefix(path.Clean(`/`+name), `/`)
+
+	if trimmed == `` {
+		trimmed = `.`
+	}
+
+	return fs.dirs[trimmed], nil
+}
+
+func (fs *memFS) RootType(string) vfs.RootType { return `` }
+func (fs *memFS) String() string               { return fmt.Sprintf("targz(%s)", fs.name) }
+
+// openTarGzBundle decodes a tar.gz bundle (the format Manifest.Bundle writes) fully into an
+// in-memory memFS.
+func openTarGzBundle(r io.Reader, name string) (vfs.FileSystem, error) {
+	gzr, err := gzip.NewReader(r)
+
+	if err != nil {
+		return nil, fmt.Errorf("%w: gzip: %v", ErrBadBundle, err)
+	}
+
+	defer gzr.Close()
+
+	var fs = newMemFS(name)
+	var tr = tar.NewReader(gzr)
+
+	for {
+		header, err := tr.Next()
+
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("%w: tar: %v", ErrBadBundle, err)
+		}
+
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		data, err := io.ReadAll(tr)
+
+		if err != nil {
+			return nil, fmt.Errorf("%w: tar: %s: %v", ErrBadBundle, header.Name, err)
+		}
+
+		fs.add(header.Name, data, os.FileMode(header.Mode), header.ModTime)
+	}
+
+	return fs, nil
+}
+
+// localMmap attempts to mmap loadpath read-only for use as an App's bundle backing store. It
+// returns ok=false (rather than an error) for anything that isn't a local, mmap-able regular
+// file -- a remote URL, for instance -- so LoadApp can fall back to reading it fully instead.
+func localMmap(loadpath string) (*mmap.ReaderAt, bool) {
+	ra, err := mmap.Open(loadpath)
+
+	if err != nil {
+		return nil, false
+	}
+
+	return ra, true
+}
@@ -0,0 +1,321 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/ghetzel/go-stockutil/fileutil"
+	"github.com/ghetzel/go-stockutil/log"
+)
+
+// CacheDir is the root of the content-addressed object store that fetched bundle assets are
+// deduplicated into.  Objects live at CacheDir/<sha256[:2]>/<sha256> and are hardlinked (falling
+// back to a copy) into each app's destdir under their manifest name.
+var CacheDir = func() string {
+	if home, err := os.UserHomeDir(); err == nil {
+		return filepath.Join(home, `.cache`, `hydra`, `objects`)
+	}
+
+	return filepath.Join(os.TempDir(), `hydra-cache`, `objects`)
+}()
+
+// DefaultFetchWorkers is the size of the worker pool used by Manifest.Fetch when
+// FetchOptions.Workers is unset.
+var DefaultFetchWorkers = runtime.NumCPU()
+
+// DefaultFetchRetries is the number of retry attempts (beyond the first) made per file before
+// Manifest.Fetch gives up on it.
+var DefaultFetchRetries = 3
+
+// ProgressEvent reports the state of a single file within a Manifest.Fetch operation.
+type ProgressEvent struct {
+	File       string
+	BytesDone  int64
+	BytesTotal int64
+	Done       bool
+	Err        error
+}
+
+// ProgressFunc receives ProgressEvents as a fetch proceeds.  Implementations must be safe to
+// call from multiple goroutines concurrently.
+type ProgressFunc func(ProgressEvent)
+
+func objectPath(sum string) string {
+	if len(sum) < 2 {
+		return filepath.Join(CacheDir, sum)
+	}
+
+	return filepath.Join(CacheDir, sum[:2], sum)
+}
+
+// linkIntoDest places the cached object at objpath at dest, preferring a hardlink (so repeated
+// installs of the same asset share disk space) and falling back to a copy when the cache and
+// destination live on different filesystems.
+func linkIntoDest(objpath string, dest string) error {
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return err
+	}
+
+	os.Remove(dest)
+
+	if err := os.Link(objpath, dest); err == nil {
+		return nil
+	}
+
+	src, err := os.Open(objpath)
+
+	if err != nil {
+		return err
+	}
+
+	defer src.Close()
+
+	_, err = fileutil.WriteFile(src, dest)
+	return err
+}
+
+// fetchToCache downloads a manifest file from srcroot into the content-addressed cache (unless
+// it's already present and valid there), retrying with exponential backoff and resuming partial
+// downloads via HTTP Range requests where the backend supports it.  It returns the path of the
+// verified cache object.
+func fetchToCache(file *ManifestFile, srcroot string, retries int, progress ProgressFunc) (string, error) {
+	var dest = objectPath(file.SHA256)
+
+	if fileutil.FileExists(dest) {
+		if cksum, err := fileutil.ChecksumFile(dest, `sha256`); err == nil && hex.EncodeToString(cksum) == file.SHA256 {
+			if progress != nil {
+				progress(ProgressEvent{File: file.Name, BytesDone: file.Size, BytesTotal: file.Size, Done: true})
+			}
+
+			return dest, nil
+		}
+	}
+
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return ``, fmt.Errorf("cache: mkdir: %w", err)
+	}
+
+	var partial = dest + `.partial`
+	var lastErr error
+
+	for attempt := 0; attempt <= retries; attempt++ {
+		if attempt > 0 {
+			var backoff = time.Duration(math.Pow(2, float64(attempt))) * 100 * time.Millisecond
+			log.Debugf("cache: retrying %s (attempt %d) after %v: %v", file.Name, attempt, backoff, lastErr)
+			time.Sleep(backoff)
+		}
+
+		if err := downloadResumable(file, srcroot, partial, progress); err == nil {
+			lastErr = nil
+			break
+		} else {
+			lastErr = err
+		}
+	}
+
+	if lastErr != nil {
+		os.Remove(partial)
+		return ``, lastErr
+	}
+
+	if cksum, err := fileutil.ChecksumFile(partial, `sha256`); err != nil || hex.EncodeToString(cksum) != file.SHA256 {
+		os.Remove(partial)
+		return ``, fmt.Errorf("%s: %w", file.Name, ErrChecksumMismatch)
+	}
+
+	if err := os.Rename(partial, dest); err != nil {
+		return ``, fmt.Errorf("cache: finalize: %w", err)
+	}
+
+	return dest, nil
+}
+
+// downloadResumable fetches file into partial, resuming from partial's current size via an
+// HTTP Range request when srcroot is an http(s) source and a partial download already exists.
+func downloadResumable(file *ManifestFile, srcroot string, partial string, progress ProgressFunc) error {
+	var offset int64
+
+	if fi, err := os.Stat(partial); err == nil {
+		offset = fi.Size()
+	}
+
+	out, err := os.OpenFile(partial, os.O_CREATE|os.O_WRONLY, 0644)
+
+	if err != nil {
+		return err
+	}
+
+	defer out.Close()
+
+	if offset > 0 {
+		if _, err := out.Seek(offset, 0); err != nil {
+			return err
+		}
+	}
+
+	rc, clen, resumed, err := openRangeFrom(file, srcroot, offset)
+
+	if err != nil {
+		return err
+	}
+
+	defer rc.Close()
+
+	// A server that doesn't honor the Range header answers 200 with the full body instead of 206
+	// with the remainder; openRangeFrom reports this via resumed=false. Leaving partial's existing
+	// bytes in place and writing the fresh full body on top of them at offset would grow the file
+	// by offset bytes every retry without ever producing a valid download, so start over instead.
+	if offset > 0 && !resumed {
+		if err := out.Truncate(0); err != nil {
+			return err
+		}
+
+		if _, err := out.Seek(0, 0); err != nil {
+			return err
+		}
+
+		offset = 0
+	}
+
+	var done = offset
+
+	_, err = copyWithProgress(out, rc, func(n int64) {
+		done += n
+
+		if progress != nil {
+			progress(ProgressEvent{File: file.Name, BytesDone: done, BytesTotal: offset + clen})
+		}
+	})
+
+	return err
+}
+
+// openRangeFrom opens file for reading starting at offset, using an HTTP Range request when
+// srcroot is a network source; otherwise it falls back to the manifest's default fetch, which
+// always returns the file from byte zero (the common case for local/archive-backed fetches, where
+// resuming doesn't save meaningful work). resumed reports whether rc actually picks up at offset
+// (a 206 response, or offset == 0) as opposed to starting over from byte zero regardless of what
+// the caller asked for (a 200 response to a Range request, meaning the server doesn't support
+// resuming) -- callers must discard any bytes they'd already written when resumed is false.
+func openRangeFrom(file *ManifestFile, srcroot string, offset int64) (rc io.ReadCloser, clen int64, resumed bool, err error) {
+	if req, rerr := http.NewRequest(http.MethodGet, joinpath(srcroot, file.Name), nil); rerr == nil {
+		if offset > 0 {
+			req.Header.Set(`Range`, fmt.Sprintf("bytes=%d-", offset))
+		}
+
+		if resp, herr := http.DefaultClient.Do(req); herr == nil {
+			switch resp.StatusCode {
+			case http.StatusPartialContent:
+				return resp.Body, resp.ContentLength, true, nil
+			case http.StatusOK:
+				return resp.Body, resp.ContentLength, offset == 0, nil
+			default:
+				resp.Body.Close()
+			}
+		}
+	}
+
+	plain, ferr := file.fetch(srcroot)
+
+	if ferr != nil {
+		return nil, 0, false, ferr
+	}
+
+	return plain, file.Size, offset == 0, nil
+}
+
+func copyWithProgress(dst *os.File, src io.Reader, onWrite func(n int64)) (int64, error) {
+	var buf = make([]byte, 32*1024)
+	var total int64
+
+	for {
+		n, rerr := src.Read(buf)
+
+		if n > 0 {
+			if _, werr := dst.Write(buf[:n]); werr != nil {
+				return total, werr
+			}
+
+			total += int64(n)
+			onWrite(int64(n))
+		}
+
+		if rerr != nil {
+			if rerr == io.EOF {
+				return total, nil
+			}
+
+			return total, rerr
+		}
+	}
+}
+
+// fetchManyToCache runs fetchToCache for each file in files across a bounded worker pool,
+// reporting progress via progress and returning the first error encountered (if any), while
+// allowing in-flight work to finish so destdir is never left with a half-extracted file.
+func fetchManyToCache(files ManifestFiles, srcroot string, workers int, retries int, progress ProgressFunc) (map[string]string, error) {
+	if workers <= 0 {
+		workers = DefaultFetchWorkers
+	}
+
+	if workers > len(files) {
+		workers = len(files)
+	}
+
+	if workers < 1 {
+		workers = 1
+	}
+
+	if retries <= 0 {
+		retries = DefaultFetchRetries
+	}
+
+	var jobs = make(chan *ManifestFile)
+	var results = make(map[string]string, len(files))
+	var mu sync.Mutex
+	var errs = make(chan error, len(files))
+	var wg sync.WaitGroup
+
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for file := range jobs {
+				objpath, err := fetchToCache(file, srcroot, retries, progress)
+
+				if err != nil {
+					errs <- fmt.Errorf("%s: %w", file.Name, err)
+					continue
+				}
+
+				mu.Lock()
+				results[file.Name] = objpath
+				mu.Unlock()
+			}
+		}()
+	}
+
+	for _, file := range files {
+		jobs <- file
+	}
+
+	close(jobs)
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		return results, err
+	}
+
+	return results, nil
+}
@@ -103,17 +103,48 @@ func main() {
 					Name:  `bundle, b`,
 					Usage: `Generate a compressed application bundle containing the files listed in the manifest.`,
 				},
+				cli.StringFlag{
+					Name:  `sign-key`,
+					Usage: `Sign the generated bundle with the given private key, writing a detached "<bundle>.sig" file alongside it.`,
+				},
+				cli.StringFlag{
+					Name:  `against`,
+					Usage: `Generate a delta bundle containing only the files that changed since this previously-generated manifest.yaml.`,
+				},
+				cli.StringFlag{
+					Name:  `patch`,
+					Usage: `The name of the file to write the delta bundle to (requires --against).`,
+					Value: `app.patch.tar.gz`,
+				},
 			},
 			Action: func(c *cli.Context) {
 				from := sliceutil.OrString(c.Args().First(), `.`)
 
 				if manifest, err := hydra.CreateManifest(from); err == nil {
+					if against := c.String(`against`); against != `` {
+						old := new(hydra.Manifest)
+						log.FatalIf(hydra.LoadManifestFile(against, old))
+
+						diff, err := manifest.BundlePatch(old, c.String(`patch`), c.String(`sign-key`))
+						log.FatalIf(err)
+
+						log.Infof(
+							"patch: %d added, %d changed, %d removed -> %s",
+							len(diff.Added), len(diff.Changed), len(diff.Removed), c.String(`patch`),
+						)
+						return
+					}
+
 					if c.Bool(`bundle`) {
 						bundleFile := filepath.Join(filepath.Dir(c.String(`output`)), `app.tar.gz`)
 
 						// generate bundle archive
 						log.FatalIf(manifest.Bundle(bundleFile))
 
+						if signKey := c.String(`sign-key`); signKey != `` {
+							log.FatalIf(manifest.Sign(signKey, hydra.SignatureFor(bundleFile)))
+						}
+
 						// replace manifest with a new one containing only the archive we just created
 						bundleManifest := hydra.NewManifest(filepath.Dir(bundleFile))
 						bundleManifest.Append(bundleFile)
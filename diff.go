@@ -0,0 +1,54 @@
+package main
+
+// ManifestDiff describes how one manifest's file set differs from an earlier one: files that
+// are new, files whose content changed (same Name, different SHA256), and files that were
+// removed entirely.
+type ManifestDiff struct {
+	Added   ManifestFiles
+	Changed ManifestFiles
+	Removed ManifestFiles
+}
+
+// IsEmpty reports whether the diff contains no changes at all.
+func (self ManifestDiff) IsEmpty() bool {
+	return len(self.Added) == 0 && len(self.Changed) == 0 && len(self.Removed) == 0
+}
+
+// Patched returns the union of Added and Changed files: everything a patch bundle built from
+// this diff needs to contain.
+func (self ManifestDiff) Patched() ManifestFiles {
+	return append(append(ManifestFiles{}, self.Added...), self.Changed...)
+}
+
+// Diff compares self (the newer manifest) against old and reports which files were added,
+// changed, or removed between the two.  Files are matched by Name; a Name present in both
+// manifests with a differing SHA256 is Changed, one present only in self is Added, and one
+// present only in old is Removed.
+func (self *Manifest) Diff(old *Manifest) ManifestDiff {
+	var diff ManifestDiff
+	var oldByName = make(map[string]*ManifestFile, len(old.Files()))
+
+	for _, file := range old.Files() {
+		oldByName[file.Name] = file
+	}
+
+	var seen = make(map[string]bool, len(self.Files()))
+
+	for _, file := range self.Files() {
+		seen[file.Name] = true
+
+		if prev, ok := oldByName[file.Name]; !ok {
+			diff.Added = append(diff.Added, file)
+		} else if prev.SHA256 != file.SHA256 {
+			diff.Changed = append(diff.Changed, file)
+		}
+	}
+
+	for _, file := range old.Files() {
+		if !seen[file.Name] {
+			diff.Removed = append(diff.Removed, file)
+		}
+	}
+
+	return diff
+}
@@ -0,0 +1,57 @@
+package main
+
+import "errors"
+
+// Sentinel errors for conditions callers need to distinguish programmatically (e.g. "retry the
+// download" vs. "abort" vs. "prompt the user for confirmation"), shared end to end across the
+// manifest/fetch layer and the app/API layer that wraps it. Wrap these with %w rather than
+// interpolating them into a new error string so errors.Is/errors.As keep working regardless of
+// which layer an error originates in -- a bad bundle detected while fetching a manifest and one
+// detected while loading an app bundle are the same condition as far as a caller is concerned.
+var (
+	ErrChecksumMismatch = errors.New(`checksum mismatch`)
+	ErrMissingAsset     = errors.New(`missing asset`)
+	ErrBadBundle        = errors.New(`bad bundle`)
+	ErrSignatureInvalid = errors.New(`invalid signature`)
+	ErrExtractFailed    = errors.New(`extract failed`)
+	ErrConfigInvalid    = errors.New(`invalid app.yaml`)
+	ErrNoSuchHandler    = errors.New(`no such action`)
+)
+
+// ErrorCode is the machine-readable identifier sent to API clients (e.g. via
+// /hydra/v1/message and /hydra/v1/events) in place of an opaque error string.
+type ErrorCode string
+
+const (
+	ErrCodeChecksumMismatch ErrorCode = `checksum_mismatch`
+	ErrCodeMissingAsset     ErrorCode = `missing_asset`
+	ErrCodeBadBundle        ErrorCode = `bad_bundle`
+	ErrCodeSignatureInvalid ErrorCode = `signature_invalid`
+	ErrCodeExtractFailed    ErrorCode = `extract_failed`
+	ErrCodeConfigInvalid    ErrorCode = `config_invalid`
+	ErrCodeNoSuchHandler    ErrorCode = `no_such_handler`
+	ErrCodeUnknown          ErrorCode = `unknown`
+)
+
+// CodeForError maps err to the ErrorCode a client should key its handling off of, falling back
+// to ErrCodeUnknown for anything that isn't one of the sentinels above.
+func CodeForError(err error) ErrorCode {
+	switch {
+	case errors.Is(err, ErrChecksumMismatch):
+		return ErrCodeChecksumMismatch
+	case errors.Is(err, ErrMissingAsset):
+		return ErrCodeMissingAsset
+	case errors.Is(err, ErrBadBundle):
+		return ErrCodeBadBundle
+	case errors.Is(err, ErrSignatureInvalid):
+		return ErrCodeSignatureInvalid
+	case errors.Is(err, ErrExtractFailed):
+		return ErrCodeExtractFailed
+	case errors.Is(err, ErrConfigInvalid):
+		return ErrCodeConfigInvalid
+	case errors.Is(err, ErrNoSuchHandler):
+		return ErrCodeNoSuchHandler
+	default:
+		return ErrCodeUnknown
+	}
+}
@@ -0,0 +1,376 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/ghetzel/go-stockutil/httputil"
+	"github.com/ghetzel/go-stockutil/log"
+	"github.com/gorilla/websocket"
+)
+
+// EventBacklogSize is the number of most-recent messages retained per topic (and for the "*"
+// catch-all) so that a /hydra/v1/events client that reconnects with a Last-Event-ID (or
+// ?since=) cursor can resume without losing anything published while it was disconnected.
+var EventBacklogSize = 256
+
+// EventHeartbeatInterval is how often an idle /hydra/v1/events connection is sent a ping (on
+// websocket) or a comment line (on SSE) to detect and recycle dead connections.
+var EventHeartbeatInterval = 30 * time.Second
+
+var eventUpgrader = websocket.Upgrader{
+	ReadBufferSize:  4096,
+	WriteBufferSize: 4096,
+	CheckOrigin:     isAllowedEventOrigin,
+}
+
+// isAllowedEventOrigin restricts the /hydra/v1/events WebSocket upgrade to same-origin and
+// loopback requests. Frames on this socket are dispatched straight to dispatchControlMessage,
+// which can start/stop/restart services, close/focus/navigate windows, and subscribe to log
+// streams -- without this check, any page the user's browser visits could open a cross-origin
+// WebSocket here and drive that whole control surface (cross-site WebSocket hijacking). A
+// request with no Origin header at all (e.g. a non-browser client, or curl) is allowed, matching
+// gorilla/websocket's own default behavior for same-origin checks.
+func isAllowedEventOrigin(req *http.Request) bool {
+	var origin = req.Header.Get(`Origin`)
+
+	if origin == `` {
+		return true
+	}
+
+	u, err := url.Parse(origin)
+
+	if err != nil {
+		return false
+	}
+
+	if u.Host == req.Host {
+		return true
+	}
+
+	if host, _, err := net.SplitHostPort(u.Host); err == nil {
+		if ip := net.ParseIP(host); ip != nil && ip.IsLoopback() {
+			return true
+		}
+	} else if ip := net.ParseIP(u.Host); ip != nil && ip.IsLoopback() {
+		return true
+	}
+
+	return false
+}
+
+// eventEnvelope is a single backlog/stream entry: a published Message plus the monotonically
+// increasing sequence number a client hands back via ?since= to resume after it.
+type eventEnvelope struct {
+	Seq     uint64   `json:"seq"`
+	Message *Message `json:"message"`
+}
+
+// eventSubscriber receives every envelope published to any of its subscribed topics on a single
+// shared, bounded channel. Publishes that would block on a full channel are dropped (counted in
+// Dropped) rather than stalling the publisher -- a slow or stuck client must not back-pressure
+// every other subscriber.
+type eventSubscriber struct {
+	topics  map[string]bool
+	c       chan *eventEnvelope
+	Dropped uint64
+}
+
+// eventHub is a topic-keyed pub/sub registry for App.Publish and /hydra/v1/events. Subscribing
+// to "*" receives every published message regardless of topic.
+type eventHub struct {
+	mu      sync.Mutex
+	seq     uint64
+	subs    map[*eventSubscriber]bool
+	backlog map[string][]*eventEnvelope
+}
+
+func newEventHub() *eventHub {
+	return &eventHub{
+		subs:    make(map[*eventSubscriber]bool),
+		backlog: make(map[string][]*eventEnvelope),
+	}
+}
+
+// subscribe registers a new subscriber for the given topics (an empty list means "*" only) and
+// returns it along with a function that unsubscribes it from the hub.
+func (hub *eventHub) subscribe(topics ...string) (*eventSubscriber, func()) {
+	if len(topics) == 0 {
+		topics = []string{`*`}
+	}
+
+	var sub = &eventSubscriber{
+		topics: make(map[string]bool),
+		c:      make(chan *eventEnvelope, AppMessageBuffer),
+	}
+
+	for _, topic := range topics {
+		sub.topics[topic] = true
+	}
+
+	hub.mu.Lock()
+	hub.subs[sub] = true
+	hub.mu.Unlock()
+
+	return sub, func() {
+		hub.mu.Lock()
+		delete(hub.subs, sub)
+		hub.mu.Unlock()
+	}
+}
+
+// replay returns the backlog for topic (or the "*" catch-all backlog if topic is "*") whose
+// sequence number is greater than since, oldest first.
+func (hub *eventHub) replay(topic string, since uint64) []*eventEnvelope {
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+
+	var out []*eventEnvelope
+
+	for _, env := range hub.backlog[topic] {
+		if env.Seq > since {
+			out = append(out, env)
+		}
+	}
+
+	return out
+}
+
+// publish assigns msg the next sequence number, appends it to topic's backlog (trimmed to
+// EventBacklogSize) and the "*" backlog, and fans it out to every subscriber of topic or "*".
+// Subscribers whose channel is full have the envelope dropped rather than blocking the caller.
+func (hub *eventHub) publish(topic string, msg *Message) uint64 {
+	hub.mu.Lock()
+	hub.seq++
+	var env = &eventEnvelope{Seq: hub.seq, Message: msg}
+
+	hub.backlog[topic] = appendBacklog(hub.backlog[topic], env)
+
+	if topic != `*` {
+		hub.backlog[`*`] = appendBacklog(hub.backlog[`*`], env)
+	}
+
+	var recipients = make([]*eventSubscriber, 0, len(hub.subs))
+
+	for sub := range hub.subs {
+		if sub.topics[topic] || sub.topics[`*`] {
+			recipients = append(recipients, sub)
+		}
+	}
+	hub.mu.Unlock()
+
+	for _, sub := range recipients {
+		select {
+		case sub.c <- env:
+		default:
+			sub.Dropped++
+			log.Warningf("events: dropping message %d for slow subscriber (topic=%s)", env.Seq, topic)
+		}
+	}
+
+	return env.Seq
+}
+
+func appendBacklog(backlog []*eventEnvelope, env *eventEnvelope) []*eventEnvelope {
+	backlog = append(backlog, env)
+
+	if len(backlog) > EventBacklogSize {
+		backlog = backlog[len(backlog)-EventBacklogSize:]
+	}
+
+	return backlog
+}
+
+// serveEvents upgrades to a websocket duplex stream at /hydra/v1/events when the request asks
+// for one, falling back to a one-way Server-Sent Events stream otherwise. Both transports accept
+// ?topic= (repeatable, defaults to "*") to select which topics to receive, and ?since= (or a
+// websocket client re-sending its last-seen seq, or the SSE Last-Event-ID header) to replay
+// whatever was published while the client was disconnected.
+func (app *App) serveEvents(w http.ResponseWriter, req *http.Request) {
+	var topics = req.URL.Query()[`topic`]
+	var since = parseSince(req)
+
+	var sub, unsubscribe = app.hub.subscribe(topics...)
+	defer unsubscribe()
+
+	if websocket.IsWebSocketUpgrade(req) {
+		app.serveEventsWebsocket(w, req, sub, since)
+	} else {
+		app.serveEventsSSE(w, req, sub, since)
+	}
+}
+
+func parseSince(req *http.Request) uint64 {
+	var raw = req.URL.Query().Get(`since`)
+
+	if raw == `` {
+		raw = req.Header.Get(`Last-Event-ID`)
+	}
+
+	since, _ := strconv.ParseUint(raw, 10, 64)
+	return since
+}
+
+// replayInto sends every backlogged envelope for sub's topics (since the given cursor) through
+// send, oldest first and without duplicates.
+func (app *App) replayInto(sub *eventSubscriber, since uint64, send func(*eventEnvelope) error) error {
+	var seen = make(map[uint64]bool)
+	var envs []*eventEnvelope
+
+	for topic := range sub.topics {
+		for _, env := range app.hub.replay(topic, since) {
+			if seen[env.Seq] {
+				continue
+			}
+			seen[env.Seq] = true
+			envs = append(envs, env)
+		}
+	}
+
+	sort.Slice(envs, func(i int, j int) bool {
+		return envs[i].Seq < envs[j].Seq
+	})
+
+	for _, env := range envs {
+		if err := send(env); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (app *App) serveEventsWebsocket(w http.ResponseWriter, req *http.Request, sub *eventSubscriber, since uint64) {
+	var conn, err = eventUpgrader.Upgrade(w, req, nil)
+
+	if err != nil {
+		log.Warningf("events: websocket upgrade failed: %v", err)
+		return
+	}
+	defer conn.Close()
+
+	var shutdown = make(chan struct{})
+	var readerDone = make(chan struct{})
+	var writeMu sync.Mutex
+
+	var writeJSON = func(v interface{}) error {
+		writeMu.Lock()
+		defer writeMu.Unlock()
+		return conn.WriteJSON(v)
+	}
+
+	if err := app.replayInto(sub, since, func(env *eventEnvelope) error {
+		return writeJSON(env)
+	}); err != nil {
+		close(shutdown)
+		return
+	}
+
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(2 * EventHeartbeatInterval))
+	})
+
+	// Inbound frames are dispatched to app.window.Send exactly like POST /hydra/v1/message,
+	// then the reply (if any) is written back over the same socket.
+	go func() {
+		defer close(readerDone)
+
+		for {
+			var msg = new(Message)
+
+			if err := conn.ReadJSON(msg); err != nil {
+				return
+			}
+
+			msg.ReceivedAt = time.Now()
+
+			if reply, err := app.window.Send(msg); err == nil {
+				if writeJSON(reply) != nil {
+					return
+				}
+			} else if writeJSON(&APIError{Code: CodeForError(err), Message: err.Error()}) != nil {
+				return
+			}
+		}
+	}()
+
+	var ticker = time.NewTicker(EventHeartbeatInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-readerDone:
+			return
+		case <-ticker.C:
+			writeMu.Lock()
+			err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(5*time.Second))
+			writeMu.Unlock()
+
+			if err != nil {
+				return
+			}
+		case env := <-sub.c:
+			if writeJSON(env) != nil {
+				return
+			}
+		case <-shutdown:
+			return
+		}
+	}
+}
+
+func (app *App) serveEventsSSE(w http.ResponseWriter, req *http.Request, sub *eventSubscriber, since uint64) {
+	var flusher, ok = w.(http.Flusher)
+
+	if !ok {
+		httputil.RespondJSON(w, apiError(fmt.Errorf("streaming unsupported")), http.StatusNotImplemented)
+		return
+	}
+
+	w.Header().Set(`Content-Type`, `text/event-stream`)
+	w.Header().Set(`Cache-Control`, `no-cache`)
+	w.Header().Set(`Connection`, `keep-alive`)
+	w.WriteHeader(http.StatusOK)
+
+	var send = func(env *eventEnvelope) error {
+		if b, err := json.Marshal(env.Message); err == nil {
+			if _, err := fmt.Fprintf(w, "id: %d\ndata: %s\n\n", env.Seq, b); err != nil {
+				return err
+			}
+			flusher.Flush()
+			return nil
+		} else {
+			return err
+		}
+	}
+
+	if err := app.replayInto(sub, since, send); err != nil {
+		return
+	}
+
+	var ticker = time.NewTicker(EventHeartbeatInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-req.Context().Done():
+			return
+		case <-ticker.C:
+			if _, err := fmt.Fprint(w, ": heartbeat\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		case env := <-sub.c:
+			if send(env) != nil {
+				return
+			}
+		}
+	}
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"os"
 	"os/signal"
+	"syscall"
 
 	"github.com/ghetzel/cli"
 	"github.com/ghetzel/go-stockutil/log"
@@ -51,6 +52,26 @@ func main() {
 			Name:  `title, T`,
 			Usage: `The window title`,
 		},
+		cli.StringFlag{
+			Name:  `backend, B`,
+			Usage: `Window backend to use: "webview" (default, embedded webkit2gtk/WebView2) or "chrome" (drive an installed Chrome/Chromium over DevTools)`,
+		},
+		cli.StringFlag{
+			Name:  `chrome-args`,
+			Usage: `Extra arguments to pass through to the Chrome binary when --backend=chrome`,
+		},
+		cli.BoolFlag{
+			Name:  `single-instance`,
+			Usage: `If another hydra process is already running this app, forward this launch's URL to it (delivered as an "open-url" event) instead of opening a second window`,
+		},
+		cli.StringSliceFlag{
+			Name:  `trusted-key`,
+			Usage: `Path to a PEM-encoded ed25519 public key (see GenerateKeypair) trusted to sign app bundles and manifest-fetched assets; may be given multiple times`,
+		},
+		cli.BoolFlag{
+			Name:  `require-signature`,
+			Usage: `Refuse to load an app bundle, or fetch a manifest-described asset, that isn't validly signed by one of --trusted-key`,
+		},
 	}
 
 	app.Before = func(c *cli.Context) error {
@@ -60,33 +81,91 @@ func main() {
 
 	app.Action = func(c *cli.Context) {
 		var loadpath = typeutil.OrString(c.Args().First(), `default`)
-		var win *Window
+		var win WindowHandle
+
+		// appName is what --single-instance keys its lockfile/socket pair on: the host component
+		// of a hydra://appname/path?... URL, or loadpath itself for a plain app name/bundle path
+		// (the same string FindAppByName resolves bundles by).
+		var appName = loadpath
+
+		if name, ok := parseHydraURL(loadpath); ok {
+			appName = name
+		}
+
+		var lock *os.File
+
+		if c.Bool(`single-instance`) {
+			if l, ok := acquireSingleInstance(appName); ok {
+				lock = l
+			} else if fwd, err := forwardURLToRunningInstance(appName, loadpath); err != nil {
+				log.FatalIf(err)
+			} else if fwd {
+				log.Noticef("single-instance: forwarded %q to the running instance of %q", loadpath, appName)
+				return
+			}
+		}
 
 		if !c.Bool(`external`) {
-			var app, err = FindAppByName(loadpath)
+			var loadOpts = LoadOptions{
+				RequireSignature: c.Bool(`require-signature`),
+				TrustedKeyPaths:  c.StringSlice(`trusted-key`),
+			}
+
+			var happ, err = FindAppByName(loadpath, loadOpts)
 			log.FatalIf(err)
 
-			win = CreateWindow(app)
+			if c.IsSet(`backend`) {
+				happ.Config.WindowBackend = c.String(`backend`)
+			}
+			if c.IsSet(`chrome-args`) {
+				happ.Config.ChromeArgs = c.String(`chrome-args`)
+			}
+
+			win, err = CreateWindow(happ)
+			log.FatalIf(err)
 		} else {
-			win = CreateWindowWithConfig(&AppConfig{
-				URL: c.Args().First(),
-			})
+			var config = &AppConfig{URL: c.Args().First()}
+
+			if c.IsSet(`backend`) {
+				config.WindowBackend = c.String(`backend`)
+			}
+			if c.IsSet(`chrome-args`) {
+				config.ChromeArgs = c.String(`chrome-args`)
+			}
+
+			var err error
+			win, err = CreateWindowWithConfig(config)
+			log.FatalIf(err)
 		}
 
 		if v := c.Int(`width`); v > 0 {
-			win.Config.Width = v
+			win.GetConfig().Width = v
 		}
 		if v := c.Int(`height`); v > 0 {
-			win.Config.Height = v
+			win.GetConfig().Height = v
 		}
 		if c.IsSet(`fullscreen`) {
-			win.Config.Fullscreen = c.Bool(`fullscreen`)
+			win.GetConfig().Fullscreen = c.Bool(`fullscreen`)
 		}
 		if c.IsSet(`title`) {
-			win.Config.Name = c.String(`title`)
+			win.GetConfig().Name = c.String(`title`)
+		}
+
+		if lock != nil {
+			if l, err := listenForSingleInstance(appName, func(u string) {
+				win.Send(&Message{ID: `open-url`, Payload: map[string]interface{}{`url`: u}})
+				win.Focus()
+			}); err == nil {
+				defer l.Close()
+			} else {
+				log.Warningf("single-instance: could not listen for forwarded URLs: %v", err)
+			}
 		}
 
 		go handleSignals(func() {
+			if lock != nil {
+				releaseSingleInstance(appName, lock)
+			}
 			win.Destroy()
 			win.Wait()
 		})
@@ -97,9 +176,14 @@ func main() {
 	app.Run(os.Args)
 }
 
+// handleSignals waits for an interrupt, SIGTERM, or SIGHUP and then runs handler -- normally a
+// graceful shutdown (drain services, close the window, wait for it) -- before exiting. SIGTERM
+// and SIGHUP are handled the same as os.Interrupt rather than left to Go's default (terminate
+// immediately, no service drain): systemd sends SIGTERM on stop/restart, and without this, the
+// process dies before win.Destroy() ever gets to stop the app's child services, orphaning them.
 func handleSignals(handler func()) {
 	var signalChan = make(chan os.Signal, 1)
-	signal.Notify(signalChan, os.Interrupt)
+	signal.Notify(signalChan, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
 
 	for _ = range signalChan {
 		handler()
@@ -1,4 +1,4 @@
-package hydra
+package main
 
 import (
 	"archive/tar"
@@ -18,6 +18,11 @@ import (
 	yaml "gopkg.in/yaml.v2"
 )
 
+// ManifestFilename is the name a generated manifest is written to (see Manifest.WriteFile) and
+// the name LoadApp looks for within a directory to decide whether it describes a manifest-
+// fetched bundle (see Manifest.Fetch) rather than an already fully-populated one.
+var ManifestFilename = `manifest.yaml`
+
 type ManifestFile struct {
 	Name         string `yaml:"name"`
 	Size         int64  `yaml:"size"`
@@ -47,17 +52,25 @@ func (self *ManifestFile) validate(root string) error {
 			if hex.EncodeToString(cksum) == self.SHA256 {
 				return nil
 			} else {
-				return fmt.Errorf("invalid local file: ")
+				return fmt.Errorf("%s: %w", self.Name, ErrChecksumMismatch)
 			}
 		} else {
-			return fmt.Errorf("malformed checksum")
+			return fmt.Errorf("%s: malformed checksum: %w", self.Name, err)
 		}
 	} else {
-		return fmt.Errorf("no such file")
+		return fmt.Errorf("%s: %w", self.Name, ErrMissingAsset)
 	}
 }
 
+// fetch retrieves the file's content.  If self.Name is an absolute URL whose scheme has a
+// registered FetchBackend (see RegisterFetchBackend), that backend handles retrieval directly
+// (allowing a single manifest to mix asset sources like s3://, oci://, git+https://, and
+// ipfs://); otherwise the name is resolved relative to root using the default fetch path.
 func (self *ManifestFile) fetch(root string) (io.ReadCloser, error) {
+	if rc, ok, err := fetchViaBackend(self.Name); ok {
+		return rc, err
+	}
+
 	return fetch(joinpath(root, self.Name))
 }
 
@@ -196,7 +209,76 @@ func (self *Manifest) Append(path string, fi ...os.FileInfo) error {
 	return nil
 }
 
-func (self *Manifest) Fetch(srcroot string, destdir string) error {
+// FetchOptions controls signature enforcement, parallelism, and progress reporting during
+// Manifest.Fetch.
+type FetchOptions struct {
+	// RequireSignature causes Fetch to refuse to extract any archive member that does not
+	// have a valid detached signature from one of TrustedKeyPaths.
+	RequireSignature bool
+	TrustedKeyPaths  []string
+
+	// Workers is the number of files fetched concurrently.  Zero uses DefaultFetchWorkers.
+	Workers int
+
+	// Retries is the number of retry attempts made per file before giving up.  Zero uses
+	// DefaultFetchRetries.
+	Retries int
+
+	// Progress, if set, is called as fetched files make headway so a CLI can display throughput.
+	Progress ProgressFunc
+
+	// Patches is an ordered chain of patch bundles (see Manifest.BundlePatch) to apply to
+	// destdir before fetching whatever's still missing, so devices on slow links only pull
+	// the files that changed since whatever base bundle already lives in destdir.
+	Patches []string
+}
+
+// AppendRemote adds an entry whose Name is an absolute URL (s3://, oci://, git+https://,
+// ipfs://, or anything else with a registered FetchBackend) rather than a path relative to the
+// manifest's srcdir.  This lets a single bundle mix locally vendored assets with ones fetched
+// on demand from one or more remote sources.
+func (self *Manifest) AppendRemote(rawurl string, size int64, sha256sum string, mime string) error {
+	if _, ok := fetchBackendFor(rawurl); !ok {
+		return errUnsupportedScheme(rawurl)
+	}
+
+	entry := &ManifestFile{
+		Name:   rawurl,
+		Size:   size,
+		SHA256: sha256sum,
+		MIME:   mime,
+	}
+
+	if IsValidModuleFile(rawurl) {
+		self.Modules = append(self.Modules, entry)
+	} else {
+		self.Assets = append(self.Assets, entry)
+	}
+
+	self.FileCount += 1
+	self.TotalSize += size
+	return nil
+}
+
+// Fetch retrieves every asset/module in the manifest that isn't already present and valid in
+// destdir.  Files are downloaded into a local content-addressed cache (keyed by SHA256) and
+// then hardlinked into destdir by name, so repeated installs and bundles that share assets
+// deduplicate on disk.  Fetching happens across a bounded worker pool with per-file retry and
+// resumable HTTP Range downloads; a failed fetch or extraction never leaves a half-written file
+// behind in destdir.
+func (self *Manifest) Fetch(srcroot string, destdir string, opts ...FetchOptions) error {
+	var opt FetchOptions
+
+	if len(opts) > 0 {
+		opt = opts[0]
+	}
+
+	for _, patch := range opt.Patches {
+		if err := ApplyPatch(destdir, patch, opt); err != nil {
+			return fmt.Errorf("%s: %w", patch, err)
+		}
+	}
+
 	var toFetch ManifestFiles
 
 	for _, file := range append(self.Assets, self.Modules...) {
@@ -208,27 +290,36 @@ func (self *Manifest) Fetch(srcroot string, destdir string) error {
 	if len(toFetch) > 0 {
 		log.Infof("fetching %d files (%v) into %s", len(toFetch), toFetch.TotalSize(), destdir)
 
+		objects, err := fetchManyToCache(toFetch, srcroot, opt.Workers, opt.Retries, opt.Progress)
+
+		if err != nil {
+			return fmt.Errorf("fetch: %w", err)
+		}
+
 		for _, file := range toFetch {
-			dest := filepath.Join(destdir, file.Name)
-			log.Debugf("fetching file: %s[%s]", srcroot, dest)
+			objpath, ok := objects[file.Name]
 
-			if rc, err := file.fetch(srcroot); err == nil {
-				defer rc.Close()
+			if !ok {
+				return fmt.Errorf("%s: %w", file.Name, ErrMissingAsset)
+			}
 
-				if _, err := fileutil.WriteFile(rc, dest); err == nil {
-					rc.Close()
-				} else {
-					return fmt.Errorf("%s: write: %v", file.Name, err)
-				}
-			} else {
-				return fmt.Errorf("%s: retrieve: %v", file.Name, err)
+			dest := filepath.Join(destdir, file.Name)
+
+			if err := linkIntoDest(objpath, dest); err != nil {
+				return fmt.Errorf("%s: link: %v", file.Name, err)
 			}
 
 			if file.Archive {
+				if err := self.verifyArchiveSignature(dest, opt); err != nil {
+					os.Remove(dest)
+					return fmt.Errorf("%s: %w", file.Name, err)
+				}
+
 				if err := extract(self, dest, destdir); err == nil {
 					file.skipValidate = true
 				} else {
-					return fmt.Errorf("%s: extract: %v", file.Name, err)
+					os.Remove(dest)
+					return fmt.Errorf("%s: %w: %v", file.Name, ErrExtractFailed, err)
 				}
 			}
 		}
@@ -241,7 +332,7 @@ func (self *Manifest) Fetch(srcroot string, destdir string) error {
 
 		if err := file.validate(destdir); err != nil {
 			os.Remove(filepath.Join(destdir, file.Name))
-			return fmt.Errorf("%s: invalid file: %v", filepath.Join(destdir, file.Name), err)
+			return fmt.Errorf("%s: %w", filepath.Join(destdir, file.Name), err)
 		}
 	}
 
@@ -252,6 +343,42 @@ func (self *Manifest) Files() ManifestFiles {
 	return append(self.Assets, self.Modules...)
 }
 
+// verifyArchiveSignature checks bundlefile against its detached signature (bundlefile + ".sig")
+// using the first key in opt.TrustedKeyPaths that validates.  If opt.RequireSignature is unset
+// and no signature file is present, verification is skipped.
+func (self *Manifest) verifyArchiveSignature(bundlefile string, opt FetchOptions) error {
+	sigfile := SignatureFor(bundlefile)
+
+	if !fileutil.FileExists(sigfile) {
+		if opt.RequireSignature {
+			return fmt.Errorf("%s: %w: missing signature file", sigfile, ErrSignatureInvalid)
+		}
+
+		return nil
+	}
+
+	if len(opt.TrustedKeyPaths) == 0 {
+		if opt.RequireSignature {
+			return fmt.Errorf("%s: %w: no trusted keys configured", sigfile, ErrSignatureInvalid)
+		}
+
+		return nil
+	}
+
+	var lastErr error
+
+	for _, keypath := range opt.TrustedKeyPaths {
+		if err := self.Verify(keypath, sigfile); err == nil {
+			log.Debugf("verify: %s signed by %s", bundlefile, keypath)
+			return nil
+		} else {
+			lastErr = err
+		}
+	}
+
+	return fmt.Errorf("%w: %v", ErrSignatureInvalid, lastErr)
+}
+
 func (self *Manifest) isAutogenerated(file *ManifestFile) bool {
 	if filepath.Ext(file.Name) == `.qml` {
 		yamlFile := fileutil.SetExt(file.Name, `.yaml`)
@@ -281,7 +408,7 @@ func (self *Manifest) Bundle(outfile string) error {
 			} else if self.isAutogenerated(file) {
 				continue
 			} else if err := file.validate(self.rootDir); err != nil {
-				return fmt.Errorf("bundle: invalid file %s: %v", file.Name, err)
+				return fmt.Errorf("bundle: %s: %w", file.Name, err)
 			}
 
 			if stat, err := file.stat(self.rootDir); err == nil {
@@ -307,10 +434,10 @@ func (self *Manifest) Bundle(outfile string) error {
 						return fmt.Errorf("bundle: read %s: %v", file.Name, err)
 					}
 				} else {
-					return fmt.Errorf("bundle: header %s: %v", file.Name, err)
+					return fmt.Errorf("bundle: %s: %w: %v", file.Name, ErrBadBundle, err)
 				}
 			} else {
-				return fmt.Errorf("bundle: file %s: %v", file.Name, err)
+				return fmt.Errorf("bundle: %s: %w: %v", file.Name, ErrBadBundle, err)
 			}
 		}
 
@@ -348,6 +475,35 @@ func (self *Manifest) WriteFile(manifestFile string) error {
 	})
 }
 
+// LoadManifestFile reads a previously-written manifest.yaml (or "-" for stdin) from
+// manifestFile into out.  It's the inverse of Manifest.WriteFile, used e.g. by
+// `hydra generate --against` to diff the current tree against an earlier release.
+func LoadManifestFile(manifestFile string, out *Manifest) error {
+	var r io.Reader
+
+	if manifestFile == `-` {
+		r = os.Stdin
+	} else if f, err := os.Open(manifestFile); err == nil {
+		defer f.Close()
+		r = f
+	} else {
+		return fmt.Errorf("%s: %w", manifestFile, err)
+	}
+
+	var application Application
+
+	if err := yaml.NewDecoder(r).Decode(&application); err != nil {
+		return fmt.Errorf("%s: %w", manifestFile, err)
+	}
+
+	if application.Manifest == nil {
+		return fmt.Errorf("%s: %w: no manifest present", manifestFile, ErrBadBundle)
+	}
+
+	*out = *application.Manifest
+	return nil
+}
+
 // Wherever an app is being developed will have it live in a source tree.  This function will
 // walk that tree and generate a manifest.yaml from it.
 //
@@ -0,0 +1,338 @@
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/ghetzel/go-stockutil/fileutil"
+	"github.com/ghetzel/go-stockutil/log"
+)
+
+// PatchMetadataFilename is the name of the JSON manifest embedded in every patch bundle
+// describing the deletions, renames, and changed-file checksums it carries.
+var PatchMetadataFilename = `patch.json`
+
+// PatchMetadata is the JSON sidecar embedded in a patch bundle (see Manifest.BundlePatch)
+// describing everything ApplyPatch needs to bring an existing tree up to date besides the
+// changed file contents themselves, which are stored alongside it in the same tar.gz.
+type PatchMetadata struct {
+	Deletions []string          `json:"deletions,omitempty"`
+	Renames   map[string]string `json:"renames,omitempty"`
+	Files     ManifestFiles     `json:"files"`
+}
+
+// patchDeletionPrefix and patchRenamePrefix mark synthetic ManifestFile entries that stand in
+// for a PatchMetadata's Deletions/Renames in patchMetadataManifest's Manifest, so that
+// Manifest.Hash() (and therefore Sign/Verify) covers them too. Both begin with a NUL byte, which
+// cannot appear in a real file path, so a crafted patch.json entry can never collide with one of
+// these and smuggle an unsigned deletion or rename past verification.
+const patchDeletionPrefix = "\x00patch-deletion:"
+const patchRenamePrefix = "\x00patch-rename:"
+
+// patchMetadataManifest returns the Manifest that BundlePatch signs and ApplyPatch verifies a
+// patch bundle against: meta.Files plus one synthetic, checksum-less entry per deletion and
+// rename. Without this, patch.json's deletions/renames could be rewritten by anyone who
+// intercepts an otherwise-legitimately-signed bundle without invalidating its signature, since
+// only the changed-file list was ever part of the signed payload.
+func patchMetadataManifest(meta *PatchMetadata) *Manifest {
+	var files = append(ManifestFiles{}, meta.Files...)
+
+	for _, name := range meta.Deletions {
+		files = append(files, &ManifestFile{Name: patchDeletionPrefix + name})
+	}
+
+	for oldName, newName := range meta.Renames {
+		files = append(files, &ManifestFile{Name: patchRenamePrefix + oldName + "\x00" + newName})
+	}
+
+	return &Manifest{Assets: files}
+}
+
+// BundlePatch writes a delta bundle to outfile containing only the files that changed between
+// old and self (self being the newer manifest), plus a PatchMetadata entry describing
+// deletions and renames.  Renames are detected as a Removed file sharing a SHA256 with an
+// Added one, so a renamed-but-unmodified QML module doesn't get re-transmitted.  If
+// privateKeyPath is given, outfile is signed the same way Manifest.Bundle's archives are (see
+// sign.go), producing the detached signature ApplyPatch's FetchOptions.RequireSignature checks.
+func (self *Manifest) BundlePatch(old *Manifest, outfile string, privateKeyPath ...string) (ManifestDiff, error) {
+	diff := self.Diff(old)
+
+	var meta = PatchMetadata{
+		Renames: make(map[string]string),
+	}
+
+	var bySha = make(map[string]string, len(diff.Added))
+
+	for _, file := range diff.Added {
+		bySha[file.SHA256] = file.Name
+	}
+
+	var removed ManifestFiles
+	var renamedTo = make(map[string]bool)
+
+	for _, file := range diff.Removed {
+		if newName, ok := bySha[file.SHA256]; ok {
+			meta.Renames[file.Name] = newName
+			renamedTo[newName] = true
+		} else {
+			removed = append(removed, file)
+			meta.Deletions = append(meta.Deletions, file.Name)
+		}
+	}
+
+	var added ManifestFiles
+
+	for _, file := range diff.Added {
+		if !renamedTo[file.Name] {
+			added = append(added, file)
+		}
+	}
+
+	diff.Added = added
+	diff.Removed = removed
+	meta.Files = diff.Patched()
+
+	targz, err := os.Create(outfile)
+
+	if err != nil {
+		return diff, fmt.Errorf("patch: %w", err)
+	}
+
+	defer targz.Close()
+
+	gzw := gzip.NewWriter(targz)
+	defer gzw.Close()
+
+	tw := tar.NewWriter(gzw)
+	defer tw.Close()
+
+	metaJSON, err := json.Marshal(&meta)
+
+	if err != nil {
+		return diff, fmt.Errorf("patch: encode metadata: %w", err)
+	}
+
+	if err := tw.WriteHeader(&tar.Header{
+		Name: PatchMetadataFilename,
+		Mode: 0644,
+		Size: int64(len(metaJSON)),
+	}); err != nil {
+		return diff, fmt.Errorf("patch: metadata header: %w", err)
+	}
+
+	if _, err := tw.Write(metaJSON); err != nil {
+		return diff, fmt.Errorf("patch: write metadata: %w", err)
+	}
+
+	for _, file := range meta.Files {
+		if err := file.validate(self.rootDir); err != nil {
+			return diff, fmt.Errorf("patch: %s: %w", file.Name, err)
+		}
+
+		stat, err := file.stat(self.rootDir)
+
+		if err != nil {
+			return diff, fmt.Errorf("patch: %s: %w", file.Name, err)
+		}
+
+		header, err := tar.FileInfoHeader(stat, ``)
+
+		if err != nil {
+			return diff, fmt.Errorf("patch: %s: %w", file.Name, err)
+		}
+
+		header.Name = file.Name
+
+		if err := tw.WriteHeader(header); err != nil {
+			return diff, fmt.Errorf("patch: %s: %w", file.Name, err)
+		}
+
+		f, err := file.open(self.rootDir)
+
+		if err != nil {
+			return diff, fmt.Errorf("patch: %s: %w", file.Name, err)
+		}
+
+		_, err = io.Copy(tw, f)
+		f.Close()
+
+		if err != nil {
+			return diff, fmt.Errorf("patch: %s: %w", file.Name, err)
+		}
+
+		log.Infof("patch: add %s", file.Name)
+	}
+
+	if len(privateKeyPath) > 0 && privateKeyPath[0] != `` {
+		if err := patchMetadataManifest(&meta).Sign(privateKeyPath[0], SignatureFor(outfile)); err != nil {
+			return diff, fmt.Errorf("patch: sign: %w", err)
+		}
+	}
+
+	return diff, nil
+}
+
+// ApplyPatch brings baseDir up to date with a single patch bundle produced by BundlePatch:
+// it extracts the patch's files into a staging copy of baseDir, checks the bundle's signature
+// (using the same opt.RequireSignature/opt.TrustedKeyPaths rules Manifest.Fetch applies to
+// archive members), applies deletions and renames, validates the result against the patch's own
+// file checksums, and atomically swaps the staging tree into place.  A failure at any point
+// leaves baseDir untouched.
+func ApplyPatch(baseDir string, patchBundle string, opts ...FetchOptions) error {
+	var opt FetchOptions
+
+	if len(opts) > 0 {
+		opt = opts[0]
+	}
+
+	staging, err := os.MkdirTemp(filepath.Dir(baseDir), `.hydra-patch-`)
+
+	if err != nil {
+		return fmt.Errorf("patch: stage: %w", err)
+	}
+
+	defer os.RemoveAll(staging)
+
+	if err := copyTree(baseDir, staging); err != nil {
+		return fmt.Errorf("patch: copy base: %w", err)
+	}
+
+	meta, err := extractPatch(patchBundle, staging)
+
+	if err != nil {
+		return fmt.Errorf("patch: %w", err)
+	}
+
+	if err := patchMetadataManifest(&meta).verifyArchiveSignature(patchBundle, opt); err != nil {
+		return fmt.Errorf("patch: %w", err)
+	}
+
+	for _, name := range meta.Deletions {
+		os.Remove(filepath.Join(staging, name))
+	}
+
+	for oldName, newName := range meta.Renames {
+		os.Rename(filepath.Join(staging, oldName), filepath.Join(staging, newName))
+	}
+
+	for _, file := range meta.Files {
+		if err := file.validate(staging); err != nil {
+			return fmt.Errorf("patch: %w", err)
+		}
+	}
+
+	var backup = baseDir + `.bak`
+	os.RemoveAll(backup)
+
+	if err := os.Rename(baseDir, backup); err != nil {
+		return fmt.Errorf("patch: swap out: %w", err)
+	}
+
+	if err := os.Rename(staging, baseDir); err != nil {
+		os.Rename(backup, baseDir)
+		return fmt.Errorf("patch: swap in: %w", err)
+	}
+
+	os.RemoveAll(backup)
+	return nil
+}
+
+// extractPatch unpacks a patch bundle's tar.gz into destdir and returns its PatchMetadata.
+func extractPatch(patchBundle string, destdir string) (meta PatchMetadata, err error) {
+	f, err := os.Open(patchBundle)
+
+	if err != nil {
+		return meta, err
+	}
+
+	defer f.Close()
+
+	gzr, err := gzip.NewReader(f)
+
+	if err != nil {
+		return meta, fmt.Errorf("gzip: %w", err)
+	}
+
+	defer gzr.Close()
+
+	tr := tar.NewReader(gzr)
+	var gotMeta bool
+
+	for {
+		header, err := tr.Next()
+
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return meta, err
+		}
+
+		if header.Name == PatchMetadataFilename {
+			if derr := json.NewDecoder(tr).Decode(&meta); derr != nil {
+				return meta, fmt.Errorf("metadata: %w", derr)
+			}
+
+			gotMeta = true
+			continue
+		}
+
+		dest := filepath.Join(destdir, header.Name)
+
+		if header.Name == `` || !strings.HasPrefix(dest, filepath.Clean(destdir)+string(filepath.Separator)) {
+			return meta, fmt.Errorf("%w: entry %q escapes destdir", ErrBadBundle, header.Name)
+		}
+
+		if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+			return meta, err
+		}
+
+		if _, err := fileutil.WriteFile(tr, dest); err != nil {
+			return meta, fmt.Errorf("%s: %w", header.Name, err)
+		}
+	}
+
+	if !gotMeta {
+		return meta, fmt.Errorf("%w: missing %s", ErrBadBundle, PatchMetadataFilename)
+	}
+
+	return meta, nil
+}
+
+// copyTree recursively copies src into dst, creating dst if necessary.  Used to stage a patch
+// application without mutating the caller's tree until the patch is known-good.
+func copyTree(src string, dst string) error {
+	return filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(src, path)
+
+		if err != nil {
+			return err
+		}
+
+		target := filepath.Join(dst, rel)
+
+		if info.IsDir() {
+			return os.MkdirAll(target, info.Mode())
+		}
+
+		in, err := os.Open(path)
+
+		if err != nil {
+			return err
+		}
+
+		defer in.Close()
+
+		_, err = fileutil.WriteFile(in, target)
+		return err
+	})
+}
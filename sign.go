@@ -0,0 +1,169 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/ghetzel/go-stockutil/fileutil"
+	"github.com/ghetzel/go-stockutil/log"
+)
+
+// SignatureFileSuffix is appended to a bundle's filename to derive the path of its
+// detached signature (e.g. "app.tar.gz" -> "app.tar.gz.sig").
+var SignatureFileSuffix = `.sig`
+
+const pemBlockTypePrivateKey = `HYDRA PRIVATE KEY`
+const pemBlockTypePublicKey = `HYDRA PUBLIC KEY`
+
+// SignatureFor returns the path of the detached signature file that accompanies bundlefile.
+func SignatureFor(bundlefile string) string {
+	return bundlefile + SignatureFileSuffix
+}
+
+// Hash computes a canonical, order-independent digest of every entry in the manifest by
+// hashing each file's already-known name and SHA256 checksum.  This lets Sign/Verify operate
+// without re-reading file contents from disk.
+func (self *Manifest) Hash() []byte {
+	var entries = append(ManifestFiles{}, self.Files()...)
+
+	sort.Slice(entries, func(i int, j int) bool {
+		return entries[i].Name < entries[j].Name
+	})
+
+	h := sha256.New()
+
+	for _, entry := range entries {
+		fmt.Fprintf(h, "%s\x00%s\n", entry.Name, entry.SHA256)
+	}
+
+	return h.Sum(nil)
+}
+
+// Sign computes the manifest's canonical hash and writes a detached ed25519 signature of it
+// to sigfile.  privateKeyPath should point to a PEM-encoded ed25519 seed as produced by
+// GenerateKeypair.
+func (self *Manifest) Sign(privateKeyPath string, sigfile string) error {
+	priv, err := loadPrivateKey(privateKeyPath)
+
+	if err != nil {
+		return fmt.Errorf("sign: %v", err)
+	}
+
+	sig := ed25519.Sign(priv, self.Hash())
+
+	if _, err := fileutil.WriteFile(pem.EncodeToMemory(&pem.Block{
+		Type:  `HYDRA SIGNATURE`,
+		Bytes: sig,
+	}), sigfile); err != nil {
+		return fmt.Errorf("sign: write %s: %v", sigfile, err)
+	}
+
+	log.Debugf("sign: wrote signature %s", sigfile)
+	return nil
+}
+
+// Verify checks that sigfile contains a valid ed25519 signature of the manifest's canonical
+// hash, produced by the private key matching pubKeyPath.
+func (self *Manifest) Verify(pubKeyPath string, sigfile string) error {
+	pub, err := loadPublicKey(pubKeyPath)
+
+	if err != nil {
+		return fmt.Errorf("verify: %v", err)
+	}
+
+	sigdata, err := os.ReadFile(sigfile)
+
+	if err != nil {
+		return fmt.Errorf("verify: read %s: %v", sigfile, err)
+	}
+
+	block, _ := pem.Decode(sigdata)
+
+	var sig []byte
+
+	if block != nil {
+		sig = block.Bytes
+	} else {
+		sig = sigdata
+	}
+
+	if !ed25519.Verify(pub, self.Hash(), sig) {
+		return fmt.Errorf("verify: signature does not match manifest")
+	}
+
+	return nil
+}
+
+// GenerateKeypair creates a new ed25519 signing keypair and writes the PEM-encoded private and
+// public halves to privateKeyPath and publicKeyPath respectively.
+func GenerateKeypair(privateKeyPath string, publicKeyPath string) error {
+	pub, priv, err := ed25519.GenerateKey(nil)
+
+	if err != nil {
+		return fmt.Errorf("keygen: %v", err)
+	}
+
+	if _, err := fileutil.WriteFile(pem.EncodeToMemory(&pem.Block{
+		Type:  pemBlockTypePrivateKey,
+		Bytes: priv.Seed(),
+	}), privateKeyPath); err != nil {
+		return fmt.Errorf("keygen: write private key: %v", err)
+	}
+
+	if _, err := fileutil.WriteFile(pem.EncodeToMemory(&pem.Block{
+		Type:  pemBlockTypePublicKey,
+		Bytes: pub,
+	}), publicKeyPath); err != nil {
+		return fmt.Errorf("keygen: write public key: %v", err)
+	}
+
+	return nil
+}
+
+func loadPrivateKey(path string) (ed25519.PrivateKey, error) {
+	data, err := os.ReadFile(path)
+
+	if err != nil {
+		return nil, fmt.Errorf("read key: %v", err)
+	}
+
+	var seed []byte
+
+	if block, _ := pem.Decode(data); block != nil {
+		seed = block.Bytes
+	} else {
+		seed = data
+	}
+
+	if len(seed) != ed25519.SeedSize {
+		return nil, fmt.Errorf("invalid private key size")
+	}
+
+	return ed25519.NewKeyFromSeed(seed), nil
+}
+
+func loadPublicKey(path string) (ed25519.PublicKey, error) {
+	data, err := os.ReadFile(path)
+
+	if err != nil {
+		return nil, fmt.Errorf("read key: %v", err)
+	}
+
+	var key []byte
+
+	if block, _ := pem.Decode(data); block != nil {
+		key = block.Bytes
+	} else {
+		key = data
+	}
+
+	if len(key) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("invalid public key size")
+	}
+
+	return ed25519.PublicKey(key), nil
+}
@@ -0,0 +1,30 @@
+//go:build linux
+
+package main
+
+/*
+#include <signal.h>
+#include <string.h>
+
+static void hydra_restore_sigaction(int sig) {
+	struct sigaction sa;
+	memset(&sa, 0, sizeof(sa));
+	sigaction(sig, NULL, &sa);
+	sa.sa_flags |= SA_ONSTACK;
+	sigaction(sig, &sa, NULL);
+}
+*/
+import "C"
+
+// installSignalWorkarounds re-installs SA_ONSTACK on the signals webkit2gtk's GLib main loop
+// likes to install its own handlers for once it initializes (SIGCHLD, SIGHUP, SIGINT, SIGQUIT,
+// SIGTERM, SIGABRT). Without it, those handlers can run on whatever undersized stack GTK was
+// using when it grabbed them and swallow or mishandle the signal before Go's own runtime signal
+// delivery ever sees it -- the proximate cause of SIGINT sometimes never reaching handleSignals
+// once a webview window is open. This must run after the window (and therefore GTK) has
+// initialized, not before, since GTK only installs its own handlers at that point.
+func installSignalWorkarounds() {
+	for _, sig := range []C.int{C.SIGCHLD, C.SIGHUP, C.SIGINT, C.SIGQUIT, C.SIGTERM, C.SIGABRT} {
+		C.hydra_restore_sigaction(sig)
+	}
+}
@@ -0,0 +1,8 @@
+//go:build !linux
+
+package main
+
+// installSignalWorkarounds is a no-op outside Linux: the SA_ONSTACK interaction it otherwise
+// works around is specific to GTK/webkit2gtk's GLib main loop, which only backs the webview on
+// Linux.
+func installSignalWorkarounds() {}
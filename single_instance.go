@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// singleInstanceRuntimeDir is where --single-instance keeps its lockfiles and sockets, preferring
+// $XDG_RUNTIME_DIR (the standard place for this kind of per-user, per-boot runtime state) and
+// falling back to the system temp dir on platforms that don't set it.
+func singleInstanceRuntimeDir() string {
+	if dir := os.Getenv(`XDG_RUNTIME_DIR`); dir != `` {
+		return dir
+	}
+
+	return os.TempDir()
+}
+
+// singleInstanceKey sanitizes appName for use as a filename component: appName defaults to
+// loadpath verbatim (see main.go) for any ordinary bundle path like "./apps/myapp.zip", so without
+// this, the resulting "hydra-./apps/myapp.zip.lock" would contain path separators pointing at
+// nonexistent intermediate directories, making the O_CREATE|O_EXCL lock/socket creation below
+// fail with ENOENT every time -- silently turning --single-instance into a no-op. Path separators
+// are replaced rather than stripped so "a/b" and "ab" can't collide.
+func singleInstanceKey(appName string) string {
+	return strings.NewReplacer(`/`, `_`, `\`, `_`).Replace(appName)
+}
+
+func singleInstanceLockPath(appName string) string {
+	return filepath.Join(singleInstanceRuntimeDir(), fmt.Sprintf("hydra-%s.lock", singleInstanceKey(appName)))
+}
+
+func singleInstanceSocketPath(appName string) string {
+	return filepath.Join(singleInstanceRuntimeDir(), fmt.Sprintf("hydra-%s.sock", singleInstanceKey(appName)))
+}
+
+// parseHydraURL extracts the name of the installed app a hydra:// URL or file:// bundle path
+// refers to, for --single-instance to key its lockfile/socket pair on. "hydra://appname/path?..."
+// names the app directly as its host component; a file:// bundle path is keyed on its base
+// filename with its final extension (e.g. .zip) stripped, matching the name FindAppByName
+// resolves .zip bundles by.
+func parseHydraURL(raw string) (appName string, ok bool) {
+	var u, err = url.Parse(raw)
+
+	if err != nil {
+		return ``, false
+	}
+
+	switch u.Scheme {
+	case `hydra`:
+		return u.Host, u.Host != ``
+	case `file`:
+		var base = filepath.Base(u.Path)
+		return strings.TrimSuffix(base, filepath.Ext(base)), base != ``
+	default:
+		return ``, false
+	}
+}
+
+// acquireSingleInstance tries to become the sole instance of appName: it creates appName's
+// lockfile exclusively and, on success, returns it (the caller should hold it open for the life
+// of the process and remove it on exit). If the lockfile already exists, acquireSingleInstance
+// checks whether the socket it should be paired with is actually being listened to; if nothing
+// answers, the previous owner crashed or was killed without cleaning up, so the stale lockfile and
+// socket are cleared and a single retry is made. ok is false if another instance is confirmed to
+// be running.
+func acquireSingleInstance(appName string) (lock *os.File, ok bool) {
+	if lock, err := os.OpenFile(singleInstanceLockPath(appName), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644); err == nil {
+		fmt.Fprintf(lock, "%d\n", os.Getpid())
+		return lock, true
+	}
+
+	if conn, err := net.Dial(`unix`, singleInstanceSocketPath(appName)); err == nil {
+		conn.Close()
+		return nil, false
+	}
+
+	os.Remove(singleInstanceLockPath(appName))
+	os.Remove(singleInstanceSocketPath(appName))
+
+	lock, err := os.OpenFile(singleInstanceLockPath(appName), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+
+	if err != nil {
+		return nil, false
+	}
+
+	fmt.Fprintf(lock, "%d\n", os.Getpid())
+	return lock, true
+}
+
+// releaseSingleInstance closes lock and removes both it and appName's socket, undoing
+// acquireSingleInstance. Call it on graceful shutdown so the next launch doesn't have to go
+// through acquireSingleInstance's stale-lock recovery path.
+func releaseSingleInstance(appName string, lock *os.File) {
+	if lock != nil {
+		lock.Close()
+	}
+
+	os.Remove(singleInstanceLockPath(appName))
+	os.Remove(singleInstanceSocketPath(appName))
+}
+
+// forwardURLToRunningInstance dials appName's socket and sends it url as a single newline-
+// terminated line, for the already-running instance's listenForSingleInstance to pick up and
+// deliver as an open-url Message. ok is false if nothing is listening (the caller should fall
+// back to becoming the primary instance itself instead of treating this as a hard failure).
+func forwardURLToRunningInstance(appName string, url string) (ok bool, err error) {
+	var conn, derr = net.Dial(`unix`, singleInstanceSocketPath(appName))
+
+	if derr != nil {
+		return false, nil
+	}
+	defer conn.Close()
+
+	_, err = fmt.Fprintf(conn, "%s\n", url)
+	return true, err
+}
+
+// listenForSingleInstance starts accepting connections on appName's socket, calling onURL with
+// each newline-terminated URL a later `hydra --single-instance` launch forwards in place of
+// opening its own window. The returned listener should be closed (and releaseSingleInstance
+// called) on shutdown.
+func listenForSingleInstance(appName string, onURL func(url string)) (net.Listener, error) {
+	var l, err = net.Listen(`unix`, singleInstanceSocketPath(appName))
+
+	if err != nil {
+		return nil, err
+	}
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+
+			if err != nil {
+				return
+			}
+
+			go func() {
+				defer conn.Close()
+
+				var scanner = bufio.NewScanner(conn)
+
+				for scanner.Scan() {
+					if u := strings.TrimSpace(scanner.Text()); u != `` {
+						onURL(u)
+					}
+				}
+			}()
+		}
+	}()
+
+	return l, nil
+}
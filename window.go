@@ -3,6 +3,7 @@ package main
 import (
 	"embed"
 	"fmt"
+	"reflect"
 	"time"
 	"unsafe"
 
@@ -19,14 +20,25 @@ var WindowDefaultHeight = 768
 var AppDefaultURL = `about:blank`
 var NativeWindowFactory NativeWindowable
 
+// WindowBackendWebview and WindowBackendChrome are the recognized values for
+// AppConfig.WindowBackend / the CLI's --backend flag. An empty/unrecognized value is treated the
+// same as WindowBackendWebview.
+const (
+	WindowBackendWebview = `webview`
+	WindowBackendChrome  = `chrome`
+)
+
+// Windowable is the lifecycle surface every window backend (the embedded webview_go view, or the
+// Chrome/Chromium-over-DevTools backend in window_chrome.go) must implement so CreateWindow and
+// CreateWindowWithConfig can build either one behind the same WindowHandle.
 type Windowable interface {
 	Navigate(url string) error
 	SetTitle(t string) error
-	Move(x int, y int) error
-	Resize(w int, height int) error
+	Resize(w int, h int) error
+	Fullscreen(on bool) error
+	Focus() error
 	Run() error
 	Destroy() error
-	Hide() error
 }
 
 type NativeWindowable interface {
@@ -37,6 +49,30 @@ type Messagable interface {
 	Send(*Message) (*Message, error)
 }
 
+// Dispatcher is implemented by window backends whose work must be posted onto a specific thread
+// (the webview backend's GUI thread) rather than run from whatever goroutine calls it. App.
+// OpenWindow uses this to build auxiliary windows on the primary window's thread.
+type Dispatcher interface {
+	Dispatch(f func())
+}
+
+// Binder is implemented by window backends that can expose native Go functions directly to the
+// embedded web view (under window.hydra.<name>, see Window.Bind), in addition to the
+// request/reply Messagable protocol.
+type Binder interface {
+	Bind(name string, fn interface{}) error
+}
+
+// WindowHandle is the full surface main.go and App need from a window backend: lifecycle control
+// (Windowable), the native/JS message bridge (Messagable), its resolved AppConfig, and the
+// ability to block the caller until the window closes.
+type WindowHandle interface {
+	Windowable
+	Messagable
+	GetConfig() *AppConfig
+	Wait()
+}
+
 type Window struct {
 	Config     *AppConfig
 	app        *App
@@ -46,36 +82,81 @@ type Window struct {
 	fullscreen bool
 	w          int
 	h          int
+	bound      map[string]bool
+	primary    bool
 }
 
-func CreateWindow(app *App) *Window {
-	var win = new(Window)
+// CreateWindow builds the window backend named by app.Config.WindowBackend (defaulting to the
+// embedded webview_go view) and wires it up to app via App.SetWindow.
+func CreateWindow(app *App) (WindowHandle, error) {
+	var win, err = newWindowHandle(app.Config)
 
-	if nw := NativeWindowFactory; nw != nil {
-		win.view = webview.NewWindow(true, nw.Pointer())
-	} else {
-		win.view = webview.New(true)
+	if err != nil {
+		return nil, err
+	}
+
+	if ab, ok := win.(interface{ setApp(*App) }); ok {
+		ab.setApp(app)
 	}
 
-	win.app = app
-	win.Config = app.Config
+	if p, ok := win.(interface{ setPrimary() }); ok {
+		p.setPrimary()
+	}
 
 	app.SetWindow(win)
 
-	return win
+	app.Bind(`openWindow`, func(cfg AppConfig) (map[string]interface{}, error) {
+		if _, id, err := app.OpenWindow(&cfg); err != nil {
+			return nil, err
+		} else {
+			return map[string]interface{}{`id`: id}, nil
+		}
+	})
+
+	return win, nil
 }
 
-func CreateWindowWithConfig(config *AppConfig) *Window {
-	var win = new(Window)
+// CreateWindowWithConfig builds the window backend named by config.WindowBackend with no
+// associated App (used for the --external URL mode, where there is no app bundle to run).
+func CreateWindowWithConfig(config *AppConfig) (WindowHandle, error) {
+	return newWindowHandle(config)
+}
 
-	if nw := NativeWindowFactory; nw != nil {
-		win.view = webview.NewWindow(true, nw.Pointer())
-	} else {
-		win.view = webview.New(true)
+func newWindowHandle(config *AppConfig) (WindowHandle, error) {
+	switch config.WindowBackend {
+	case WindowBackendChrome:
+		return newChromeWindow(config)
+	default:
+		var win = new(Window)
+
+		if nw := NativeWindowFactory; nw != nil {
+			win.view = webview.NewWindow(true, nw.Pointer())
+		} else {
+			win.view = webview.New(true)
+		}
+
+		win.Config = config
+
+		// Must run after webview.New/NewWindow, since that's the point GTK actually installs
+		// its own signal handlers for installSignalWorkarounds to fix up (see signal_linux.go).
+		installSignalWorkarounds()
+
+		return win, nil
 	}
+}
+
+// GetConfig satisfies WindowHandle; main.go uses it in place of direct field access so it works
+// the same way regardless of which backend CreateWindow resolved to.
+func (window *Window) GetConfig() *AppConfig {
+	return window.Config
+}
 
-	win.Config = config
-	return win
+func (window *Window) setApp(app *App) {
+	window.app = app
+}
+
+func (window *Window) setPrimary() {
+	window.primary = true
 }
 
 func (window *Window) init() error {
@@ -98,6 +179,14 @@ func (window *Window) init() error {
 			return err
 		}
 
+		if window.app != nil {
+			for name, fn := range window.app.handlers {
+				if err := window.Bind(name, fn); err != nil {
+					return err
+				}
+			}
+		}
+
 		window.SetTitle(window.Config.Name)
 		window.Resize(window.Config.Width, window.Config.Height)
 
@@ -132,12 +221,25 @@ func (window *Window) Run() error {
 	return window.lasterr
 }
 
+// Destroy closes the window. Only the primary window's Destroy stops the app's services --
+// auxiliary windows opened via App.OpenWindow share those services with the primary window and
+// the rest of the app, and closing a dialog shouldn't take the whole app down with it.
 func (window *Window) Destroy() error {
-	window.app.Config.Services.Stop(false)
+	if window.primary && window.app != nil {
+		window.app.Config.Services.Stop(false)
+	}
+
 	window.view.Destroy()
 	return nil
 }
 
+// Focus asks the embedded page to focus itself. webview_go has no portable API to raise/focus
+// the native window itself, so this is a best-effort substitute rather than a true window-manager
+// level focus request.
+func (window *Window) Focus() error {
+	return window.Eval(`window.focus();`)
+}
+
 func (window *Window) Wait() {
 	if svc := window.Config.Services; svc != nil {
 		svc.Wait()
@@ -178,32 +280,57 @@ func (window *Window) Fullscreen(on bool) error {
 	return nil
 }
 
-func (window *Window) Send(req *Message) (*Message, error) {
-	var reply = new(Message)
-	var err error
+// ProgramStatus is the per-program snapshot returned in the reply payload of a start/stop/
+// restart/status Message, keyed by program name.
+type ProgramStatus struct {
+	PID          int    `json:"pid"`
+	State        string `json:"state"`
+	Uptime       string `json:"uptime"`
+	LastExitCode int    `json:"last_exit_code"`
+}
 
-	reply.ID = req.ID
-	reply.ReceivedAt = req.ReceivedAt
-	reply.SentAt = time.Now()
+// wantsProgram reports whether name matches the "name" (single) or "names" (list) Payload
+// fields of req. An empty/absent name and names matches everything, which preserves start/stop/
+// restart's old broadcast-to-everyone behavior for callers that don't name a target.
+func wantsProgram(req *Message, name string) bool {
+	var wanted = req.Get(`names`).Strings()
+
+	if n := req.Get(`name`).String(); n != `` {
+		wanted = append(wanted, n)
+	}
+
+	if len(wanted) == 0 {
+		return true
+	}
+
+	for _, w := range wanted {
+		if w == name {
+			return true
+		}
+	}
 
+	return false
+}
+
+// dispatchControlMessage handles the backend-agnostic half of Messagable.Send: logging, per-
+// program start/stop/restart/status, and log streaming, which every Windowable implementation
+// supports identically. It returns handled=false for message IDs (resize, move) that the
+// caller's backend has to resolve itself, since what "resize" or "move" means depends on how the
+// backend drives its window.
+func dispatchControlMessage(app *App, req *Message, reply *Message) (handled bool, err error) {
 	switch req.ID {
 	case `log`:
 		var lvl = log.GetLevel(req.Get(`level`, `debug`).String())
 		log.Log(lvl, req.Get(`message`, `-- MARK --`).String())
+		return true, nil
 
-	case `resize`:
-		var w = req.Get(`w`, WindowDefaultWidth).NInt()
-		var h = req.Get(`h`, WindowDefaultHeight).NInt()
-		err = window.Resize(w, h)
+	case `start`, `stop`, `restart`, `status`:
+		var statuses = make(map[string]ProgramStatus)
 
-	case `move`:
-		var x = req.Get(`x`).NInt()
-		var y = req.Get(`y`).NInt()
-		err = window.Move(x, y)
-
-	case `start`, `stop`, `restart`:
-		for _, program := range window.app.Config.Services.Manager.Programs() {
-			var e error
+		for _, program := range app.Config.Services.Manager.Programs() {
+			if !wantsProgram(req, program.Name()) {
+				continue
+			}
 
 			switch req.ID {
 			case `start`:
@@ -214,12 +341,216 @@ func (window *Window) Send(req *Message) (*Message, error) {
 				program.Restart()
 			}
 
-			err = log.AppendError(err, e)
+			statuses[program.Name()] = ProgramStatus{
+				PID:          program.PID(),
+				State:        program.State(),
+				Uptime:       program.Uptime().String(),
+				LastExitCode: program.LastExitCode(),
+			}
 		}
 
+		reply.Set(`programs`, statuses)
+		return true, nil
+
+	case `logs`:
+		var name = req.Get(`name`).String()
+		var topic = `logs:` + name
+		var found bool
+
+		for _, program := range app.Config.Services.Manager.Programs() {
+			if program.Name() != name {
+				continue
+			}
+
+			found = true
+
+			// A client that sends "logs" more than once for the same program (e.g. after a
+			// websocket reconnect) must not stack a second forwarder on top of the first one --
+			// subscribeLogsOnce reports whether this program is already subscribed and no-ops the
+			// OnOutput registration if so, since the existing forwarder already publishes to topic.
+			if !app.subscribeLogsOnce(name) {
+				program.OnOutput(func(stream string, line string) {
+					app.Publish(topic, map[string]interface{}{
+						`name`:   name,
+						`stream`: stream,
+						`line`:   line,
+					})
+				})
+			}
+
+			break
+		}
+
+		if !found {
+			return true, fmt.Errorf("%w: no such program %q", ErrNoSuchHandler, name)
+		}
+
+		reply.Set(`topic`, topic)
+		return true, nil
+
+	// open-url is what a --single-instance launch's forwarded hydra:// URL (or file:// bundle
+	// path) arrives as once listenForSingleInstance hands it to the already-running instance's
+	// window (see main.go): published on the "open-url" topic rather than handled here directly,
+	// so the app's own JS can react to it (navigate, open a document, ...) the same way it
+	// reacts to any other /hydra/v1/events subscription.
+	case `open-url`:
+		app.Publish(`open-url`, map[string]interface{}{`url`: req.Get(`url`).String()})
+		return true, nil
+
+	case `window.close`, `window.focus`, `window.navigate`:
+		var id = req.Get(`id`).String()
+
+		if id == `` {
+			return true, fmt.Errorf("%w: %q requires an \"id\"", ErrNoSuchHandler, req.ID)
+		}
+
+		win, ok := app.windowByID(id)
+
+		if !ok {
+			return true, fmt.Errorf("%w: no such window %q", ErrNoSuchHandler, id)
+		}
+
+		switch req.ID {
+		case `window.close`:
+			return true, win.Destroy()
+
+		case `window.focus`:
+			return true, win.Focus()
+
+		case `window.navigate`:
+			return true, win.Navigate(req.Get(`url`).String())
+		}
+
+		return true, nil
+
 	default:
-		err = fmt.Errorf("no such action %q", req.ID)
+		return false, nil
+	}
+}
+
+func (window *Window) Send(req *Message) (*Message, error) {
+	var reply = new(Message)
+	var err error
+
+	reply.ID = req.ID
+	reply.ReceivedAt = req.ReceivedAt
+	reply.SentAt = time.Now()
+
+	if handled, e := dispatchControlMessage(window.app, req, reply); handled {
+		err = e
+	} else {
+		switch req.ID {
+		case `resize`:
+			var w = req.Get(`w`, WindowDefaultWidth).NInt()
+			var h = req.Get(`h`, WindowDefaultHeight).NInt()
+			err = window.Resize(w, h)
+
+		case `move`:
+			var x = req.Get(`x`).NInt()
+			var y = req.Get(`y`).NInt()
+			err = window.Move(x, y)
+
+		default:
+			err = fmt.Errorf("%w %q", ErrNoSuchHandler, req.ID)
+		}
 	}
 
 	return reply, err
 }
+
+// Dispatch posts f to run on the window's own UI thread, same as the underlying webview's
+// Dispatch. Use this instead of calling view methods directly from a goroutine.
+func (window *Window) Dispatch(f func()) {
+	window.view.Dispatch(f)
+}
+
+// Eval runs js in the web view asynchronously; its result (and any exception it throws) is
+// discarded, matching the underlying webview's fire-and-forget Eval. The error return exists so
+// Eval satisfies the same signature as Bind's other JS-facing methods and so a future backend
+// whose Eval *can* fail (e.g. lorca, see chunk1-2) doesn't need a different interface.
+func (window *Window) Eval(js string) error {
+	window.view.Eval(js)
+	return nil
+}
+
+// Bind registers fn as window.hydra.<name> in the embedded web view: JS calls
+// `await hydra.call(name, ...args)` (or `window.hydra[name](...args)` directly) and get back a
+// promise resolved or rejected with fn's (JSON-marshalled) return value or error, exactly like a
+// bare webview Bind, except that a panic inside fn is recovered and surfaced as a rejection
+// instead of crashing the process.
+func (window *Window) Bind(name string, fn interface{}) error {
+	if err := validateBindFunc(fn); err != nil {
+		return err
+	}
+
+	var internal = `__hydra_bind_` + name
+
+	if err := window.view.Bind(internal, recoverBindFunc(fn)); err != nil {
+		return err
+	}
+
+	if window.bound == nil {
+		window.bound = make(map[string]bool)
+	}
+	window.bound[name] = true
+
+	window.view.Init(fmt.Sprintf(
+		"window.hydra = window.hydra || {}; window.hydra.%s = window.%s;",
+		name, internal,
+	))
+
+	return nil
+}
+
+// validateBindFunc checks that fn is something webview.Bind (and recoverBindFunc) can actually
+// handle: a function returning at most a value and/or an error.
+func validateBindFunc(fn interface{}) error {
+	var v = reflect.ValueOf(fn)
+
+	if v.Kind() != reflect.Func {
+		return fmt.Errorf("hydra: bound handler must be a function, got %T", fn)
+	}
+
+	if n := v.Type().NumOut(); n > 2 {
+		return fmt.Errorf("hydra: bound handler may only return a value and/or an error")
+	} else if n == 2 && !v.Type().Out(1).Implements(reflect.TypeOf((*error)(nil)).Elem()) {
+		return fmt.Errorf("hydra: bound handler may only return a value and/or an error")
+	}
+
+	return nil
+}
+
+// recoverBindFunc wraps fn (already validated by validateBindFunc) in a function of the same
+// signature that recovers a panic in fn and turns it into fn's error return instead of letting it
+// unwind into the cgo callback and crash the process. If fn has no error return, the panic is
+// logged instead, since there is no return slot to carry it back to the JS promise.
+func recoverBindFunc(fn interface{}) interface{} {
+	var v = reflect.ValueOf(fn)
+	var t = v.Type()
+	var errorType = reflect.TypeOf((*error)(nil)).Elem()
+
+	return reflect.MakeFunc(t, func(args []reflect.Value) (out []reflect.Value) {
+		defer func() {
+			if r := recover(); r != nil {
+				out = make([]reflect.Value, t.NumOut())
+
+				var handled bool
+
+				for i := 0; i < t.NumOut(); i++ {
+					if t.Out(i).Implements(errorType) {
+						out[i] = reflect.ValueOf(fmt.Errorf("panic in bound handler: %v", r))
+						handled = true
+					} else {
+						out[i] = reflect.Zero(t.Out(i))
+					}
+				}
+
+				if !handled {
+					log.Errorf("hydra: panic in bound handler with no error return: %v", r)
+				}
+			}
+		}()
+
+		return v.Call(args)
+	}).Interface()
+}
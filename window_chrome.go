@@ -0,0 +1,713 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"reflect"
+	"regexp"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ghetzel/go-stockutil/log"
+	"github.com/ghetzel/go-stockutil/stringutil"
+	"github.com/gorilla/websocket"
+)
+
+// ChromeCandidates is the list of binary names searched via exec.LookPath, in order, when looking
+// for a Chrome/Chromium binary for WindowBackendChrome. If none is found, findChromeBinary falls
+// back to well-known absolute install paths on macOS and Windows.
+var ChromeCandidates = []string{
+	`google-chrome`,
+	`google-chrome-stable`,
+	`chromium`,
+	`chromium-browser`,
+	`chrome`,
+}
+
+var chromeDevtoolsLine = regexp.MustCompile(`DevTools listening on (ws://\S+)`)
+var chromeConnectTimeout = 10 * time.Second
+
+// ChromeWindow is the Windowable+Messagable backend that launches the user's installed Chrome or
+// Chromium in `--app=` (no browser chrome) mode and drives it over its DevTools remote debugging
+// protocol instead of embedding webkit2gtk/WebView2 in-process. This gets hydra apps running on
+// systems without an embeddable webview, and gives them access to whatever Chromium in use
+// supports (WebRTC, WebGPU, extensions, ...).
+type ChromeWindow struct {
+	Config *AppConfig
+	app    *App
+
+	primary  bool
+	targetID string
+
+	cmd  *exec.Cmd
+	conn *websocket.Conn
+
+	writeMu sync.Mutex
+	nextID  uint64
+	pending sync.Map // uint64 -> chan cdpMessage
+
+	boundMu     sync.Mutex
+	bound       map[string]func(args []json.RawMessage) (interface{}, error)
+	libInjected bool
+
+	exited chan struct{}
+	err    error
+}
+
+func newChromeWindow(config *AppConfig) (*ChromeWindow, error) {
+	return &ChromeWindow{
+		Config: config,
+		exited: make(chan struct{}),
+	}, nil
+}
+
+func (cw *ChromeWindow) setApp(app *App) {
+	cw.app = app
+}
+
+func (cw *ChromeWindow) setPrimary() {
+	cw.primary = true
+}
+
+// Dispatch runs f immediately on the calling goroutine. Unlike the webview backend, driving
+// Chrome over DevTools has no OS-thread affinity requirement, so there is no queue to post f onto
+// -- this only exists so ChromeWindow satisfies Dispatcher for App.OpenWindow's benefit.
+func (cw *ChromeWindow) Dispatch(f func()) {
+	f()
+}
+
+// cdpMessage is a single DevTools protocol frame: a request/response pair keyed by ID, or an
+// unsolicited event identified by Method (see https://chromedevtools.github.io/devtools-protocol/).
+type cdpMessage struct {
+	ID     uint64          `json:"id,omitempty"`
+	Method string          `json:"method,omitempty"`
+	Params json.RawMessage `json:"params,omitempty"`
+	Result json.RawMessage `json:"result,omitempty"`
+	Error  *struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	} `json:"error,omitempty"`
+}
+
+func findChromeBinary() (string, error) {
+	for _, name := range ChromeCandidates {
+		if p, err := exec.LookPath(name); err == nil {
+			return p, nil
+		}
+	}
+
+	var wellKnown []string
+
+	switch runtime.GOOS {
+	case `darwin`:
+		wellKnown = []string{
+			`/Applications/Google Chrome.app/Contents/MacOS/Google Chrome`,
+			`/Applications/Chromium.app/Contents/MacOS/Chromium`,
+		}
+	case `windows`:
+		wellKnown = []string{
+			`C:\Program Files\Google\Chrome\Application\chrome.exe`,
+			`C:\Program Files (x86)\Google\Chrome\Application\chrome.exe`,
+		}
+	}
+
+	for _, path := range wellKnown {
+		if _, err := os.Stat(path); err == nil {
+			return path, nil
+		}
+	}
+
+	return ``, fmt.Errorf("chrome backend: no Chrome/Chromium binary found (tried %v)", append(ChromeCandidates, wellKnown...))
+}
+
+// launch starts Chrome in app mode against url, scrapes its "DevTools listening on ws://..."
+// stderr line for the browser's debugger endpoint, and dials it.
+func (cw *ChromeWindow) launch(url string) error {
+	var bin, err = findChromeBinary()
+
+	if err != nil {
+		return err
+	}
+
+	var profileDir, perr = os.MkdirTemp(``, `hydra-chrome-*`)
+
+	if perr != nil {
+		return perr
+	}
+
+	var args = []string{
+		`--remote-debugging-port=0`,
+		`--user-data-dir=` + profileDir,
+		`--no-first-run`,
+		`--no-default-browser-check`,
+		`--app=` + url,
+	}
+
+	if cw.Config.ChromeArgs != `` {
+		args = append(args, stringutil.SplitTrimSpace(cw.Config.ChromeArgs, ` `)...)
+	}
+
+	cw.cmd = exec.Command(bin, args...)
+
+	var stderr, serr = cw.cmd.StderrPipe()
+
+	if serr != nil {
+		return serr
+	}
+
+	if err := cw.cmd.Start(); err != nil {
+		return err
+	}
+
+	var debugURLC = make(chan string, 1)
+
+	go func() {
+		var scanner = bufio.NewScanner(stderr)
+
+		for scanner.Scan() {
+			if m := chromeDevtoolsLine.FindStringSubmatch(scanner.Text()); m != nil {
+				select {
+				case debugURLC <- m[1]:
+				default:
+				}
+			}
+		}
+	}()
+
+	go func() {
+		cw.err = cw.cmd.Wait()
+		os.RemoveAll(profileDir)
+		close(cw.exited)
+	}()
+
+	select {
+	case debugURL := <-debugURLC:
+		return cw.connect(debugURL)
+	case <-cw.exited:
+		return fmt.Errorf("chrome backend: process exited before DevTools became available")
+	case <-time.After(chromeConnectTimeout):
+		cw.cmd.Process.Kill()
+		return fmt.Errorf("chrome backend: timed out waiting for DevTools endpoint")
+	}
+}
+
+// connect resolves the single page target's own WebSocket debugger URL off of the browser
+// endpoint's HTTP companion (http://host:port/json/list) and dials that, since --app= opens
+// exactly one tab and everything this backend needs (Page, Runtime, Browser domains) is
+// reachable directly on a page-level session without going through the Target domain.
+func (cw *ChromeWindow) connect(browserWS string) error {
+	var httpBase = `http://` + extractHostPort(browserWS)
+
+	var resp, err = http.Get(httpBase + `/json/list`)
+
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	var targets []struct {
+		ID                   string `json:"id"`
+		Type                 string `json:"type"`
+		WebSocketDebuggerURL string `json:"webSocketDebuggerUrl"`
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(&targets); err != nil {
+		return err
+	}
+
+	var pageWS string
+
+	for _, t := range targets {
+		if t.Type == `page` {
+			pageWS = t.WebSocketDebuggerURL
+			cw.targetID = t.ID
+			break
+		}
+	}
+
+	if pageWS == `` {
+		return fmt.Errorf("chrome backend: no page target found")
+	}
+
+	var conn, _, derr = websocket.DefaultDialer.Dial(pageWS, nil)
+
+	if derr != nil {
+		return derr
+	}
+
+	cw.conn = conn
+	go cw.readLoop()
+
+	if _, err := cw.send(`Page.enable`, nil); err != nil {
+		return err
+	}
+	if _, err := cw.send(`Runtime.enable`, nil); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func extractHostPort(wsURL string) string {
+	var s = wsURL
+
+	if i := indexAfter(s, `://`); i >= 0 {
+		s = s[i:]
+	}
+	if i := indexOf(s, `/`); i >= 0 {
+		s = s[:i]
+	}
+
+	return s
+}
+
+func indexAfter(s, sep string) int {
+	for i := 0; i+len(sep) <= len(s); i++ {
+		if s[i:i+len(sep)] == sep {
+			return i + len(sep)
+		}
+	}
+	return -1
+}
+
+func indexOf(s, sep string) int {
+	for i := 0; i+len(sep) <= len(s); i++ {
+		if s[i:i+len(sep)] == sep {
+			return i
+		}
+	}
+	return -1
+}
+
+// readLoop dispatches every incoming frame to whichever caller is blocked in send() waiting on
+// that ID, or, for frames with no ID (CDP events), to handleEvent.
+func (cw *ChromeWindow) readLoop() {
+	for {
+		var msg cdpMessage
+
+		if err := cw.conn.ReadJSON(&msg); err != nil {
+			return
+		}
+
+		if msg.ID != 0 {
+			if ch, ok := cw.pending.Load(msg.ID); ok {
+				ch.(chan cdpMessage) <- msg
+			}
+		} else if msg.Method != `` {
+			cw.handleEvent(msg)
+		}
+	}
+}
+
+func (cw *ChromeWindow) handleEvent(msg cdpMessage) {
+	if msg.Method != `Runtime.bindingCalled` {
+		return
+	}
+
+	var params struct {
+		Name    string `json:"name"`
+		Payload string `json:"payload"`
+	}
+
+	if err := json.Unmarshal(msg.Params, &params); err != nil {
+		return
+	}
+
+	cw.boundMu.Lock()
+	var fn = cw.bound[params.Name]
+	cw.boundMu.Unlock()
+
+	if fn == nil {
+		return
+	}
+
+	go cw.invokeBinding(params.Name, fn, params.Payload)
+}
+
+// invokeBinding unmarshals payload (a JSON array of the call's arguments, same wire shape
+// webview_go uses) into fn, calls it, and resolves or rejects the JS-side promise (set up by the
+// init script Bind installs) by evaluating a script that calls window.__hydra_resolve or
+// window.__hydra_reject with the binding's name and the (marshalled) result.
+func (cw *ChromeWindow) invokeBinding(name string, fn func(args []json.RawMessage) (interface{}, error), payload string) {
+	var raw []json.RawMessage
+
+	if err := json.Unmarshal([]byte(payload), &raw); err != nil {
+		cw.resolveBinding(name, nil, err)
+		return
+	}
+
+	func() {
+		defer func() {
+			if r := recover(); r != nil {
+				cw.resolveBinding(name, nil, fmt.Errorf("panic in bound handler: %v", r))
+			}
+		}()
+
+		result, err := fn(raw)
+		cw.resolveBinding(name, result, err)
+	}()
+}
+
+func (cw *ChromeWindow) resolveBinding(name string, result interface{}, err error) {
+	var expr string
+
+	if err != nil {
+		b, _ := json.Marshal(err.Error())
+		expr = fmt.Sprintf(`window.__hydra_reject("%s", %s)`, name, b)
+	} else {
+		b, merr := json.Marshal(result)
+
+		if merr != nil {
+			b, _ = json.Marshal(merr.Error())
+			expr = fmt.Sprintf(`window.__hydra_reject("%s", %s)`, name, b)
+		} else {
+			expr = fmt.Sprintf(`window.__hydra_resolve("%s", %s)`, name, b)
+		}
+	}
+
+	cw.send(`Runtime.evaluate`, map[string]interface{}{`expression`: expr})
+}
+
+// send issues a CDP command and blocks for its response.
+func (cw *ChromeWindow) send(method string, params interface{}) (json.RawMessage, error) {
+	var id = atomic.AddUint64(&cw.nextID, 1)
+	var reply = make(chan cdpMessage, 1)
+
+	cw.pending.Store(id, reply)
+	defer cw.pending.Delete(id)
+
+	var raw, err = json.Marshal(params)
+
+	if err != nil {
+		return nil, err
+	}
+
+	cw.writeMu.Lock()
+	err = cw.conn.WriteJSON(&cdpMessage{ID: id, Method: method, Params: raw})
+	cw.writeMu.Unlock()
+
+	if err != nil {
+		return nil, err
+	}
+
+	select {
+	case msg := <-reply:
+		if msg.Error != nil {
+			return nil, fmt.Errorf("chrome backend: %s: %s", method, msg.Error.Message)
+		}
+		return msg.Result, nil
+	case <-time.After(chromeConnectTimeout):
+		return nil, fmt.Errorf("chrome backend: %s timed out", method)
+	}
+}
+
+func (cw *ChromeWindow) Navigate(url string) error {
+	if cw.conn == nil {
+		return cw.launch(url)
+	}
+
+	_, err := cw.send(`Page.navigate`, map[string]interface{}{`url`: url})
+	return err
+}
+
+func (cw *ChromeWindow) SetTitle(title string) error {
+	_, err := cw.send(`Runtime.evaluate`, map[string]interface{}{
+		`expression`: fmt.Sprintf(`document.title = %q`, title),
+	})
+	return err
+}
+
+func (cw *ChromeWindow) Resize(w int, h int) error {
+	var winResult, err = cw.send(`Browser.getWindowForTarget`, nil)
+
+	if err != nil {
+		return err
+	}
+
+	var target struct {
+		WindowID int `json:"windowId"`
+	}
+
+	if err := json.Unmarshal(winResult, &target); err != nil {
+		return err
+	}
+
+	_, err = cw.send(`Browser.setWindowBounds`, map[string]interface{}{
+		`windowId`: target.WindowID,
+		`bounds`:   map[string]interface{}{`width`: w, `height`: h},
+	})
+
+	return err
+}
+
+func (cw *ChromeWindow) Fullscreen(on bool) error {
+	var winResult, err = cw.send(`Browser.getWindowForTarget`, nil)
+
+	if err != nil {
+		return err
+	}
+
+	var target struct {
+		WindowID int `json:"windowId"`
+	}
+
+	if err := json.Unmarshal(winResult, &target); err != nil {
+		return err
+	}
+
+	var state = `normal`
+
+	if on {
+		state = `fullscreen`
+	}
+
+	_, err = cw.send(`Browser.setWindowBounds`, map[string]interface{}{
+		`windowId`: target.WindowID,
+		`bounds`:   map[string]interface{}{`windowState`: state},
+	})
+
+	return err
+}
+
+func (cw *ChromeWindow) Run() error {
+	if cw.conn == nil {
+		if err := cw.launch(cw.Config.URL); err != nil {
+			return err
+		}
+	}
+
+	if cw.app != nil {
+		go log.FatalIf(cw.app.Run(func(a *App) error {
+			go a.Config.Services.Run()
+			return nil
+		}))
+	}
+
+	if cw.Config.Fullscreen {
+		if err := cw.Fullscreen(true); err != nil {
+			log.Warningf("chrome backend: fullscreen failed: %v", err)
+		}
+	} else {
+		if err := cw.Resize(cw.Config.Width, cw.Config.Height); err != nil {
+			log.Warningf("chrome backend: resize failed: %v", err)
+		}
+	}
+
+	cw.Wait()
+	return cw.err
+}
+
+// Focus brings Chrome's window to the front via the DevTools protocol's Target.activateTarget,
+// which (unlike the webview backend's Focus) is a real window-manager-level activation rather
+// than a same-page window.focus() call.
+func (cw *ChromeWindow) Focus() error {
+	if cw.targetID == `` {
+		return fmt.Errorf("chrome backend: no target to focus")
+	}
+
+	_, err := cw.send(`Target.activateTarget`, map[string]interface{}{`targetId`: cw.targetID})
+	return err
+}
+
+// Destroy closes the window. Only the primary window's Destroy stops the app's services --
+// auxiliary windows opened via App.OpenWindow share those services with the primary window and
+// the rest of the app, same reasoning as Window.Destroy.
+func (cw *ChromeWindow) Destroy() error {
+	if cw.primary && cw.app != nil && cw.app.Config.Services != nil {
+		cw.app.Config.Services.Stop(false)
+	}
+
+	if cw.conn != nil {
+		cw.conn.Close()
+	}
+
+	if cw.cmd != nil && cw.cmd.Process != nil {
+		cw.cmd.Process.Kill()
+	}
+
+	return nil
+}
+
+func (cw *ChromeWindow) Wait() {
+	<-cw.exited
+}
+
+func (cw *ChromeWindow) GetConfig() *AppConfig {
+	return cw.Config
+}
+
+func (cw *ChromeWindow) Send(req *Message) (*Message, error) {
+	var reply = new(Message)
+	var err error
+
+	reply.ID = req.ID
+	reply.ReceivedAt = req.ReceivedAt
+	reply.SentAt = time.Now()
+
+	if handled, e := dispatchControlMessage(cw.app, req, reply); handled {
+		err = e
+	} else {
+		switch req.ID {
+		case `resize`:
+			var w = req.Get(`w`, WindowDefaultWidth).NInt()
+			var h = req.Get(`h`, WindowDefaultHeight).NInt()
+			err = cw.Resize(w, h)
+
+		case `move`:
+			err = fmt.Errorf("Move: Not Implemented")
+
+		default:
+			err = fmt.Errorf("%w %q", ErrNoSuchHandler, req.ID)
+		}
+	}
+
+	return reply, err
+}
+
+// Bind registers fn as window.hydra.<name> via the DevTools protocol's Runtime.addBinding (the
+// same native-function-exposure primitive lorca uses in place of webview_go's Bind): calling
+// window.hydra.<name>(...) in the page serializes its arguments, sends them to Chrome's
+// "binding called" event, which handleEvent routes to fn, and the result (or error) is delivered
+// back by evaluating a small resolve/reject script against the pending promise the init snippet
+// below sets up.
+func (cw *ChromeWindow) Bind(name string, fn interface{}) error {
+	if err := validateBindFunc(fn); err != nil {
+		return err
+	}
+
+	var wrapped = wrapBindFuncForChrome(fn)
+
+	cw.boundMu.Lock()
+	if cw.bound == nil {
+		cw.bound = make(map[string]func(args []json.RawMessage) (interface{}, error))
+	}
+	cw.bound[name] = wrapped
+	var needsLib = !cw.libInjected
+	cw.libInjected = true
+	cw.boundMu.Unlock()
+
+	// Inject the same lib/js/hydra.js library Window.init embeds for the webview backend, so
+	// window.hydra.call(name, ...) works under WindowBackend: chrome too, not just direct
+	// window.hydra.<name>(...) calls. Only needs doing once per window.
+	if needsLib {
+		if jslib, err := FS.ReadFile(WindowEmbeddedLibraryPath); err == nil {
+			if _, err := cw.send(`Page.addScriptToEvaluateOnNewDocument`, map[string]interface{}{`source`: string(jslib)}); err != nil {
+				return err
+			}
+
+			if _, err := cw.send(`Runtime.evaluate`, map[string]interface{}{`expression`: string(jslib)}); err != nil {
+				return err
+			}
+		} else {
+			return err
+		}
+	}
+
+	if _, err := cw.send(`Runtime.addBinding`, map[string]interface{}{`name`: name}); err != nil {
+		return err
+	}
+
+	var script = fmt.Sprintf(`(function(){
+	window.hydra = window.hydra || {};
+	window.__hydra_pending = window.__hydra_pending || {};
+	window.__hydra_resolve = window.__hydra_resolve || function(n, v) {
+		var p = window.__hydra_pending[n] && window.__hydra_pending[n].shift();
+		if (p) p.resolve(v);
+	};
+	window.__hydra_reject = window.__hydra_reject || function(n, v) {
+		var p = window.__hydra_pending[n] && window.__hydra_pending[n].shift();
+		if (p) p.reject(new Error(v));
+	};
+	window.hydra.%s = function() {
+		var args = Array.prototype.slice.call(arguments);
+		return new Promise(function(resolve, reject) {
+			window.__hydra_pending[%q] = window.__hydra_pending[%q] || [];
+			window.__hydra_pending[%q].push({resolve: resolve, reject: reject});
+			%s(JSON.stringify(args));
+		});
+	};
+})();`, name, name, name, name, name)
+
+	_, err := cw.send(`Page.addScriptToEvaluateOnNewDocument`, map[string]interface{}{`source`: script})
+
+	if err != nil {
+		return err
+	}
+
+	_, err = cw.send(`Runtime.evaluate`, map[string]interface{}{`expression`: script})
+	return err
+}
+
+// wrapBindFuncForChrome adapts fn (validated by validateBindFunc, same constraints as the
+// webview backend's Bind) into the raw []json.RawMessage-in/(interface{}, error)-out shape
+// invokeBinding calls, doing the same reflection-based argument unmarshalling and result
+// marshalling that webview_go's own Bind performs internally.
+func wrapBindFuncForChrome(fn interface{}) func(args []json.RawMessage) (interface{}, error) {
+	var safe = recoverBindFunc(fn)
+	return reflectCallJSON(safe)
+}
+
+// reflectCallJSON adapts fn into the []json.RawMessage-in/(interface{}, error)-out shape
+// ChromeWindow.invokeBinding calls, unmarshalling each raw argument into fn's declared parameter
+// type and interpreting its return values the same way webview_go's own Bind does: zero results
+// means (nil, nil), one error-typed result is just the error, one other result is the value, and
+// two results are (value, error).
+func reflectCallJSON(fn interface{}) func(args []json.RawMessage) (interface{}, error) {
+	var v = reflect.ValueOf(fn)
+	var t = v.Type()
+	var errorType = reflect.TypeOf((*error)(nil)).Elem()
+
+	return func(raw []json.RawMessage) (interface{}, error) {
+		var isVariadic = t.IsVariadic()
+		var numIn = t.NumIn()
+
+		if (isVariadic && len(raw) < numIn-1) || (!isVariadic && len(raw) != numIn) {
+			return nil, errors.New("hydra: bound handler argument count mismatch")
+		}
+
+		var args = make([]reflect.Value, 0, len(raw))
+
+		for i := range raw {
+			var arg reflect.Value
+
+			if isVariadic && i >= numIn-1 {
+				arg = reflect.New(t.In(numIn - 1).Elem())
+			} else {
+				arg = reflect.New(t.In(i))
+			}
+
+			if err := json.Unmarshal(raw[i], arg.Interface()); err != nil {
+				return nil, err
+			}
+
+			args = append(args, arg.Elem())
+		}
+
+		var res = v.Call(args)
+
+		switch len(res) {
+		case 0:
+			return nil, nil
+		case 1:
+			if res[0].Type().Implements(errorType) {
+				if res[0].IsNil() {
+					return nil, nil
+				}
+				return nil, res[0].Interface().(error)
+			}
+			return res[0].Interface(), nil
+		default:
+			if res[1].IsNil() {
+				return res[0].Interface(), nil
+			}
+			return res[0].Interface(), res[1].Interface().(error)
+		}
+	}
+}